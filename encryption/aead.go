@@ -0,0 +1,210 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Authenticated encryption (AEAD) replacing EncryptWithKey/DecryptWithKey.
+// Tampered ciphertext, a wrong key or wrong associated data fail with
+// ErrAEADOpen instead of decrypting to garbage.
+//
+// Usage:
+//
+//	key, _ := encryption.NewAEADKey() // 32 random bytes, store it safely
+//	blob, err := encryption.Seal(encryption.AES256GCM, key, []byte("secret"), []byte("user:42"))
+//	plain, err := encryption.Open(key, blob, []byte("user:42"))
+//
+//	// string form, same shape as EncryptWithKey (key is a 32 byte string)
+//	enc, err := encryption.EncryptAEAD("secret", key32, "user:42")
+//	dec, err := encryption.DecryptAEAD(enc, key32, "user:42")
+//
+//	// migration: reads both formats, upgraded is set when data was a legacy CFB blob
+//	plain, upgraded, err := encryption.DecryptAndMigrate(stored, legacyKey, key32, "user:42")
+//	if upgraded != "" {
+//		saveToDB(upgraded)
+//	}
+//
+// Envelope (binary): version (1 byte) | algorithm (1 byte) | nonce | ciphertext+tag.
+// The version and algorithm bytes are authenticated together with the
+// associated data, so they cannot be swapped. The string form is
+// AEAD_STRING_PREFIX + base64url(envelope); the prefix cannot appear in the
+// base64url output of EncryptWithKey, which is how legacy blobs are told apart.
+
+type AEADAlgorithm byte
+
+const (
+	AES256GCM         AEADAlgorithm = 1 // 12 byte nonce
+	XChaCha20Poly1305 AEADAlgorithm = 2 // 24 byte nonce, safe with random nonces for any number of messages
+
+	AEAD_VERSION_1     byte = 1
+	AEAD_KEY_SIZE           = 32
+	AEAD_STRING_PREFIX      = "aead:"
+)
+
+var (
+	ErrAEADOpen             = errors.New("encryption: message authentication failed")
+	ErrAEADKeySize          = errors.New("encryption: key must be 32 bytes")
+	ErrAEADUnknownVersion   = errors.New("encryption: unknown envelope version")
+	ErrAEADUnknownAlgorithm = errors.New("encryption: unknown aead algorithm")
+	ErrAEADTooShort         = errors.New("encryption: ciphertext too short")
+)
+
+func (a AEADAlgorithm) String() string {
+	switch a {
+	case AES256GCM:
+		return "AES-256-GCM"
+	case XChaCha20Poly1305:
+		return "XChaCha20-Poly1305"
+	}
+	return fmt.Sprintf("AEADAlgorithm(%d)", byte(a))
+}
+
+// NewAEADKey generates a random 32 byte key
+func NewAEADKey() ([]byte, error) {
+	key := make([]byte, AEAD_KEY_SIZE)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newAEAD(alg AEADAlgorithm, key []byte) (cipher.AEAD, error) {
+	if len(key) != AEAD_KEY_SIZE {
+		return nil, ErrAEADKeySize
+	}
+	switch alg {
+	case AES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	case XChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	}
+	return nil, ErrAEADUnknownAlgorithm
+}
+
+// Seal encrypts plaintext into a self-describing envelope, aad (may be nil) is
+// authenticated but not encrypted and must be given again to Open
+func Seal(alg AEADAlgorithm, key, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newAEAD(alg, key)
+	if err != nil {
+		return nil, err
+	}
+	header := []byte{AEAD_VERSION_1, byte(alg)}
+	envelope := make([]byte, len(header)+aead.NonceSize(), len(header)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(envelope, header)
+	nonce := envelope[len(header):]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(envelope, nonce, plaintext, envelopeAAD(header, aad)), nil
+}
+
+// Open decrypts an envelope made by Seal, the algorithm is read from it
+func Open(key, envelope, aad []byte) ([]byte, error) {
+	if len(envelope) < 2 {
+		return nil, ErrAEADTooShort
+	}
+	if envelope[0] != AEAD_VERSION_1 {
+		return nil, ErrAEADUnknownVersion
+	}
+	aead, err := newAEAD(AEADAlgorithm(envelope[1]), key)
+	if err != nil {
+		return nil, err
+	}
+	header := envelope[:2]
+	if len(envelope) < len(header)+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrAEADTooShort
+	}
+	nonce := envelope[len(header) : len(header)+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, envelope[len(header)+aead.NonceSize():], envelopeAAD(header, aad))
+	if err != nil {
+		return nil, ErrAEADOpen
+	}
+	return plaintext, nil
+}
+
+// EnvelopeAlgorithm returns the algorithm of an envelope without decrypting it
+func EnvelopeAlgorithm(envelope []byte) (AEADAlgorithm, error) {
+	if len(envelope) < 2 {
+		return 0, ErrAEADTooShort
+	}
+	if envelope[0] != AEAD_VERSION_1 {
+		return 0, ErrAEADUnknownVersion
+	}
+	return AEADAlgorithm(envelope[1]), nil
+}
+
+func envelopeAAD(header, aad []byte) []byte {
+	return append(append(make([]byte, 0, len(header)+len(aad)), header...), aad...)
+}
+
+// EncryptAEAD is the string version of Seal with AES-256-GCM, key must be 32
+// bytes (ie: 32 ascii characters)
+func EncryptAEAD(data, key, aad string) (string, error) {
+	return EncryptAEADWith(AES256GCM, data, key, aad)
+}
+
+// EncryptAEADWith is EncryptAEAD with the algorithm of choice
+func EncryptAEADWith(alg AEADAlgorithm, data, key, aad string) (string, error) {
+	envelope, err := Seal(alg, []byte(key), []byte(data), []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return AEAD_STRING_PREFIX + base64.RawURLEncoding.EncodeToString(envelope), nil
+}
+
+// DecryptAEAD decrypts the output of EncryptAEAD, legacy blobs are rejected
+func DecryptAEAD(data, key, aad string) (string, error) {
+	if !IsAEADString(data) {
+		return "", ErrAEADUnknownVersion
+	}
+	envelope, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(data, AEAD_STRING_PREFIX))
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := Open([]byte(key), envelope, []byte(aad))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsAEADString is true for the output of EncryptAEAD, false for legacy
+// EncryptWithKey blobs
+func IsAEADString(data string) bool {
+	return strings.HasPrefix(data, AEAD_STRING_PREFIX)
+}
+
+// DecryptAndMigrate decrypts both formats. legacyKey is the key of the old
+// EncryptWithKey blobs (16, 24 or 32 bytes), newKey the 32 byte key of
+// EncryptAEAD (may be the same string when the legacy key is 32 bytes). For a
+// legacy blob (no integrity, aad is not checked) upgraded is the same plaintext
+// encrypted with EncryptAEAD under newKey, store it in place of the old value.
+// For data already in the new format upgraded is empty. When only the
+// re-encryption fails the plaintext is still returned, together with the error.
+func DecryptAndMigrate(data, legacyKey, newKey, aad string) (plaintext, upgraded string, err error) {
+	if IsAEADString(data) {
+		plaintext, err = DecryptAEAD(data, newKey, aad)
+		return plaintext, "", err
+	}
+	plaintext, err = DecryptWithKey(data, legacyKey)
+	if err != nil {
+		return "", "", err
+	}
+	upgraded, err = EncryptAEAD(plaintext, newKey, aad)
+	if err != nil {
+		return plaintext, "", err
+	}
+	return plaintext, upgraded, nil
+}
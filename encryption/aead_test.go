@@ -0,0 +1,103 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+const aeadTestKey = "0123456789abcdef0123456789abcdef"
+
+func TestSealOpen(t *testing.T) {
+	key := []byte(aeadTestKey)
+	for _, alg := range []AEADAlgorithm{AES256GCM, XChaCha20Poly1305} {
+		blob, err := Seal(alg, key, []byte("secret"), []byte("user:42"))
+		if err != nil {
+			t.Fatalf("%s: Seal: %v", alg, err)
+		}
+		if got, err := EnvelopeAlgorithm(blob); err != nil || got != alg {
+			t.Fatalf("%s: EnvelopeAlgorithm = %v, %v", alg, got, err)
+		}
+		plain, err := Open(key, blob, []byte("user:42"))
+		if err != nil || string(plain) != "secret" {
+			t.Fatalf("%s: Open = %q, %v", alg, plain, err)
+		}
+
+		if _, err := Open(key, blob, []byte("user:43")); !errors.Is(err, ErrAEADOpen) {
+			t.Errorf("%s: wrong aad err = %v", alg, err)
+		}
+		tampered := bytes.Clone(blob)
+		tampered[len(tampered)-1] ^= 1
+		if _, err := Open(key, tampered, []byte("user:42")); !errors.Is(err, ErrAEADOpen) {
+			t.Errorf("%s: tampered err = %v", alg, err)
+		}
+		// the algorithm byte is authenticated too
+		swapped := bytes.Clone(blob)
+		swapped[1] = byte(AES256GCM + XChaCha20Poly1305 - alg)
+		if _, err := Open(key, swapped, []byte("user:42")); err == nil {
+			t.Errorf("%s: swapped algorithm opened", alg)
+		}
+	}
+}
+
+func TestOpenInvalid(t *testing.T) {
+	key := []byte(aeadTestKey)
+	if _, err := Seal(AES256GCM, []byte("short"), nil, nil); !errors.Is(err, ErrAEADKeySize) {
+		t.Errorf("short key err = %v", err)
+	}
+	if _, err := Open(key, []byte{AEAD_VERSION_1}, nil); !errors.Is(err, ErrAEADTooShort) {
+		t.Errorf("too short err = %v", err)
+	}
+	if _, err := Open(key, []byte{9, byte(AES256GCM), 0}, nil); !errors.Is(err, ErrAEADUnknownVersion) {
+		t.Errorf("version err = %v", err)
+	}
+	if _, err := Open(key, []byte{AEAD_VERSION_1, 9, 0}, nil); !errors.Is(err, ErrAEADUnknownAlgorithm) {
+		t.Errorf("algorithm err = %v", err)
+	}
+}
+
+func TestDecryptAndMigrate(t *testing.T) {
+	legacy, err := EncryptWithKey("my secret data", aeadTestKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsAEADString(legacy) {
+		t.Fatalf("legacy blob %q looks like aead", legacy)
+	}
+	if _, err := DecryptAEAD(legacy, aeadTestKey, ""); err == nil {
+		t.Fatal("DecryptAEAD accepted a legacy blob")
+	}
+
+	plain, upgraded, err := DecryptAndMigrate(legacy, aeadTestKey, aeadTestKey, "row:1")
+	if err != nil || plain != "my secret data" || !IsAEADString(upgraded) {
+		t.Fatalf("migrate legacy = %q, %q, %v", plain, upgraded, err)
+	}
+	plain, again, err := DecryptAndMigrate(upgraded, aeadTestKey, aeadTestKey, "row:1")
+	if err != nil || plain != "my secret data" || again != "" {
+		t.Fatalf("migrate upgraded = %q, %q, %v", plain, again, err)
+	}
+	if _, _, err := DecryptAndMigrate(upgraded, aeadTestKey, aeadTestKey, "row:2"); !errors.Is(err, ErrAEADOpen) {
+		t.Fatalf("migrated blob opened with other aad, err = %v", err)
+	}
+}
+
+func TestDecryptAndMigrateShortLegacyKey(t *testing.T) {
+	legacyKey := "0123456789abcdef" // 16 bytes, fine for EncryptWithKey
+	legacy, err := EncryptWithKey("my secret data", legacyKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, upgraded, err := DecryptAndMigrate(legacy, legacyKey, aeadTestKey, "row:1")
+	if err != nil || plain != "my secret data" || !IsAEADString(upgraded) {
+		t.Fatalf("migrate = %q, %q, %v", plain, upgraded, err)
+	}
+	if plain, err := DecryptAEAD(upgraded, aeadTestKey, "row:1"); err != nil || plain != "my secret data" {
+		t.Fatalf("upgraded = %q, %v", plain, err)
+	}
+
+	// a bad new key still gives the plaintext of the legacy blob
+	plain, upgraded, err = DecryptAndMigrate(legacy, legacyKey, legacyKey, "row:1")
+	if !errors.Is(err, ErrAEADKeySize) || plain != "my secret data" || upgraded != "" {
+		t.Fatalf("bad new key = %q, %q, %v", plain, upgraded, err)
+	}
+}
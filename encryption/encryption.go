@@ -74,6 +74,10 @@ func CreateHash(key string) string {
 // HashPin hashes a PIN using scrypt with salts (key1 and key2).
 // Usage: hash, err := HashPin("1234", "signature", "2025-04-16")
 // Output: Base64-encoded hash (e.g., "c29tZS1oYXNoLXZhbHVl")
+//
+// Deprecated: the salt is the PIN itself, so equal PINs give equal hashes and
+// the parameters are fixed. Use HashPassword/VerifyPassword, after a successful
+// login with HashPin store HashPassword(pin) instead.
 func HashPin(pin, key1, key2 string) (string, error) {
 	salt := []byte(pin)
 
@@ -99,6 +103,10 @@ func SHA256(s string) string {
 	return fmt.Sprintf("%x", sum)
 }
 
+// EncryptWithKey encrypts data with AES in CFB mode, the result is Base64 (URL) encoded.
+//
+// Deprecated: CFB has no integrity check, tampered data decrypts to garbage
+// without an error. Use EncryptAEAD, and DecryptAndMigrate to upgrade stored blobs.
 func EncryptWithKey(data, key string) (string, error) {
 	encryptionKey := []byte(key)
 	block, err := aes.NewCipher(encryptionKey)
@@ -124,6 +132,8 @@ func EncryptWithKey(data, key string) (string, error) {
 // DecryptWithKey decrypts a Base64-encoded ciphertext using AES in CFB mode with the provided key.
 // Usage: decrypted, err := DecryptWithKey(encrypted, "my32byteencryptionkey!")
 // Output: "my secret data"
+//
+// Deprecated: use DecryptAEAD, or DecryptAndMigrate while old blobs still exist.
 func DecryptWithKey(data, key string) (string, error) {
 	encryptionKey := []byte(key)
 	block, err := aes.NewCipher(encryptionKey)
@@ -3,60 +3,336 @@ package encryption
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"strings"
 
 	"github.com/medatechnology/goutil/medaerror"
 )
 
-// Standard JWE format
+// Standard JWE format (RFC 7516 compact serialization)
 // BASE64URL(UTF8(Protected Header)).BASE64URL(Encrypted Key).BASE64URL(IV).BASE64URL(Ciphertext).BASE64URL(Authentication Tag)
-// Protected Header: {"alg":"A128GCM","enc":"A128GCM"}
-// Encrypted Key: Encrypted symmetric key (optional, not used in this example)
+// Protected Header: {"alg":"dir","enc":"A256GCM"}, authenticated as AAD
+// Encrypted Key: the content encryption key (CEK) encrypted for the recipient, empty for dir and ECDH-ES
 // IV: Initialization Vector (nonce)
 // Ciphertext: Encrypted payload
-// Authentication Tag: Used for integrity check (not used in this example)
-// NOTE: This is a simplified example. In a real-world application, you should handle errors and edge cases properly.
+// Authentication Tag: Used for integrity check
+//
+// Usage:
+//
+//	// shared secret, alg dir, enc picked from the key size (16/24/32 bytes -> A128GCM/A192GCM/A256GCM)
+//	token, err := encryption.CreateJWE(payload, key32)
+//	payload, err := encryption.ParseJWE(token, key32)
+//
+//	// other key management, the key type must fit alg
+//	token, err = encryption.EncryptJWE(payload, rsaPublicKey, encryption.JWEOptions{Alg: encryption.JWE_ALG_RSA_OAEP_256, Enc: encryption.JWE_ENC_A256GCM})
+//	token, err = encryption.EncryptJWE(payload, kek32, encryption.JWEOptions{Alg: encryption.JWE_ALG_A256KW, Enc: encryption.JWE_ENC_A128CBC_HS256})
+//	token, err = encryption.EncryptJWE(payload, ecdsaPublicKey, encryption.JWEOptions{Alg: encryption.JWE_ALG_ECDH_ES, Enc: encryption.JWE_ENC_A256GCM})
+//	payload, header, err := encryption.DecryptJWE(token, rsaPrivateKey)
+//
+// Keys for DecryptJWE: []byte for dir and A256KW, *rsa.PrivateKey for
+// RSA-OAEP-256, *ecdsa.PrivateKey or *ecdh.PrivateKey for ECDH-ES. A token
+// whose alg does not fit the key type is rejected, so the sender cannot pick
+// the algorithm. ParseJWE still reads the old three-part tokens
+// (header.nonce.ciphertext) made by earlier versions of CreateJWE.
+
+const (
+	JWE_ALG_DIR          = "dir"
+	JWE_ALG_A256KW       = "A256KW"
+	JWE_ALG_RSA_OAEP_256 = "RSA-OAEP-256"
+	JWE_ALG_ECDH_ES      = "ECDH-ES"
+
+	JWE_ENC_A128GCM       = "A128GCM"
+	JWE_ENC_A192GCM       = "A192GCM"
+	JWE_ENC_A256GCM       = "A256GCM"
+	JWE_ENC_A128CBC_HS256 = "A128CBC-HS256"
+
+	JWE_MIN_RSA_BITS = 2048
+)
+
+var (
+	ErrJWEMalformed   = errors.New("jwe: malformed token")
+	ErrJWEUnsupported = errors.New("jwe: unsupported algorithm")
+	ErrJWEKeyType     = errors.New("jwe: key does not fit the algorithm")
+	ErrJWEDecrypt     = errors.New("jwe: decryption failed")
+)
+
+// CEK size in bytes of each content encryption
+var jweEncKeySize = map[string]int{
+	JWE_ENC_A128GCM:       16,
+	JWE_ENC_A192GCM:       24,
+	JWE_ENC_A256GCM:       32,
+	JWE_ENC_A128CBC_HS256: 32,
+}
+
+// JWEHeader is the protected header
+type JWEHeader struct {
+	Alg string  `json:"alg"`
+	Enc string  `json:"enc"`
+	Kid string  `json:"kid,omitempty"`
+	Typ string  `json:"typ,omitempty"`
+	Cty string  `json:"cty,omitempty"`
+	Epk *jweJWK `json:"epk,omitempty"` // ECDH-ES ephemeral public key
+	Apu string  `json:"apu,omitempty"` // ECDH-ES PartyUInfo, base64url
+	Apv string  `json:"apv,omitempty"` // ECDH-ES PartyVInfo, base64url
+	Zip string  `json:"zip,omitempty"`
+	// header parameters the recipient must understand, none are supported
+	Crit []string `json:"crit,omitempty"`
+}
+
+type jweJWK struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type JWEOptions struct {
+	Alg        string // JWE_ALG_*, default dir
+	Enc        string // JWE_ENC_*, default A256GCM
+	Kid        string
+	Typ        string
+	Cty        string
+	PartyUInfo []byte // ECDH-ES only
+	PartyVInfo []byte // ECDH-ES only
+}
+
+// jweErr keeps err for errors.Is/As under the medaerror message
+func jweErr(message string, err error) error {
+	e := medaerror.Errorf("%s: %v", message, err)
+	e.Err = err
+	return e
+}
+
 // Create JWE token
 // The payload is the data you want to encrypt
-// The key is the symmetric key used for encryption
+// The key is the symmetric key used for encryption (alg dir), 16, 24 or 32 bytes
 func CreateJWE(payload []byte, key []byte) (string, error) {
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return "", medaerror.Errorf("creating cipher: %w", err)
+	var enc string
+	switch len(key) {
+	case 16:
+		enc = JWE_ENC_A128GCM
+	case 24:
+		enc = JWE_ENC_A192GCM
+	case 32:
+		enc = JWE_ENC_A256GCM
+	default:
+		return "", jweErr("creating jwe", ErrJWEKeyType)
 	}
+	return EncryptJWE(payload, key, JWEOptions{Alg: JWE_ALG_DIR, Enc: enc})
+}
 
-	aesgcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return "", medaerror.Errorf("creating GCM: %w", err)
+// EncryptJWE creates a compact JWE for the recipient key: []byte for dir and
+// A256KW, *rsa.PublicKey for RSA-OAEP-256, *ecdsa.PublicKey or *ecdh.PublicKey
+// for ECDH-ES
+func EncryptJWE(payload []byte, key interface{}, opts JWEOptions) (string, error) {
+	if opts.Alg == "" {
+		opts.Alg = JWE_ALG_DIR
 	}
-
-	nonce := make([]byte, aesgcm.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
-		return "", medaerror.Errorf("generating nonce: %w", err)
+	if opts.Enc == "" {
+		opts.Enc = JWE_ENC_A256GCM
 	}
+	cekSize, ok := jweEncKeySize[opts.Enc]
+	if !ok {
+		return "", jweErr("enc "+opts.Enc, ErrJWEUnsupported)
+	}
+	header := JWEHeader{Alg: opts.Alg, Enc: opts.Enc, Kid: opts.Kid, Typ: opts.Typ, Cty: opts.Cty}
 
-	ciphertext := aesgcm.Seal(nil, nonce, payload, nil)
+	var cek, encryptedKey []byte
+	switch opts.Alg {
+	case JWE_ALG_DIR:
+		k, ok := key.([]byte)
+		if !ok || len(k) != cekSize {
+			return "", jweErr("alg dir with "+opts.Enc, ErrJWEKeyType)
+		}
+		cek = k
+	case JWE_ALG_A256KW:
+		kek, ok := key.([]byte)
+		if !ok || len(kek) != 32 {
+			return "", jweErr("alg A256KW", ErrJWEKeyType)
+		}
+		cek = make([]byte, cekSize)
+		if _, err := rand.Read(cek); err != nil {
+			return "", jweErr("generating cek", err)
+		}
+		wrapped, err := aesKeyWrap(kek, cek)
+		if err != nil {
+			return "", jweErr("wrapping cek", err)
+		}
+		encryptedKey = wrapped
+	case JWE_ALG_RSA_OAEP_256:
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok || pub.N.BitLen() < JWE_MIN_RSA_BITS {
+			return "", jweErr("alg RSA-OAEP-256", ErrJWEKeyType)
+		}
+		cek = make([]byte, cekSize)
+		if _, err := rand.Read(cek); err != nil {
+			return "", jweErr("generating cek", err)
+		}
+		encrypted, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, cek, nil)
+		if err != nil {
+			return "", jweErr("encrypting cek", err)
+		}
+		encryptedKey = encrypted
+	case JWE_ALG_ECDH_ES:
+		pub, err := ecdhPublicKey(key)
+		if err != nil {
+			return "", err
+		}
+		ephemeral, err := pub.Curve().GenerateKey(rand.Reader)
+		if err != nil {
+			return "", jweErr("generating ephemeral key", err)
+		}
+		z, err := ephemeral.ECDH(pub)
+		if err != nil {
+			return "", jweErr("key agreement", err)
+		}
+		header.Epk, err = jwkFromECDH(ephemeral.PublicKey())
+		if err != nil {
+			return "", err
+		}
+		header.Apu = base64.RawURLEncoding.EncodeToString(opts.PartyUInfo)
+		header.Apv = base64.RawURLEncoding.EncodeToString(opts.PartyVInfo)
+		cek = concatKDF(z, opts.Enc, opts.PartyUInfo, opts.PartyVInfo, cekSize)
+	default:
+		return "", jweErr("alg "+opts.Alg, ErrJWEUnsupported)
+	}
 
-	header := map[string]string{"alg": "A128GCM", "enc": "A128GCM"}
-	headerBytes, _ := json.Marshal(header)
+	headerBytes, err := json.Marshal(header)
+	if err != nil {
+		return "", jweErr("marshaling header", err)
+	}
 	encodedHeader := base64.RawURLEncoding.EncodeToString(headerBytes)
-	encodedCiphertext := base64.RawURLEncoding.EncodeToString(ciphertext)
-	encodedNonce := base64.RawURLEncoding.EncodeToString(nonce)
-
-	jwe := strings.Join([]string{encodedHeader, encodedNonce, encodedCiphertext}, ".")
-	return jwe, nil
+	iv, ciphertext, tag, err := jweSeal(opts.Enc, cek, payload, []byte(encodedHeader))
+	if err != nil {
+		return "", err
+	}
+	return strings.Join([]string{
+		encodedHeader,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
 }
 
 // Parse JWE token
 // The JWE string is the token you want to decrypt
-// The key is the symmetric key used for decryption
-// The function returns the decrypted payload
-// Example usage:
-// jweString := "eyJhbGciOiJBMjU2R0NNIiwiZW5jIjoiQTEyOEdDTSJ9.h79q
+// The key is the symmetric key used for decryption (dir or A256KW)
+// The function returns the decrypted payload, tokens in the old three-part
+// format are still accepted
 func ParseJWE(jweString string, key []byte) ([]byte, error) {
+	if strings.Count(jweString, ".") == 2 {
+		return parseLegacyJWE(jweString, key)
+	}
+	payload, _, err := DecryptJWE(jweString, key)
+	return payload, err
+}
+
+// DecryptJWE decrypts a compact JWE and returns the payload with the protected
+// header, see the file comment for the key types
+func DecryptJWE(token string, key interface{}) ([]byte, JWEHeader, error) {
+	var header JWEHeader
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return nil, header, jweErr("invalid JWE format", ErrJWEMalformed)
+	}
+	decoded := make([][]byte, 5)
+	for i, part := range parts {
+		b, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return nil, header, jweErr("decoding part", ErrJWEMalformed)
+		}
+		decoded[i] = b
+	}
+	if err := json.Unmarshal(decoded[0], &header); err != nil {
+		return nil, header, jweErr("unmarshaling header", ErrJWEMalformed)
+	}
+	if len(header.Crit) > 0 || header.Zip != "" {
+		return nil, header, jweErr("header crit/zip", ErrJWEUnsupported)
+	}
+	cekSize, ok := jweEncKeySize[header.Enc]
+	if !ok {
+		return nil, header, jweErr("enc "+header.Enc, ErrJWEUnsupported)
+	}
+	encryptedKey := decoded[1]
+
+	var cek []byte
+	switch header.Alg {
+	case JWE_ALG_DIR:
+		k, ok := key.([]byte)
+		if !ok || len(k) != cekSize || len(encryptedKey) != 0 {
+			return nil, header, jweErr("alg dir", ErrJWEKeyType)
+		}
+		cek = k
+	case JWE_ALG_A256KW:
+		kek, ok := key.([]byte)
+		if !ok || len(kek) != 32 {
+			return nil, header, jweErr("alg A256KW", ErrJWEKeyType)
+		}
+		unwrapped, err := aesKeyUnwrap(kek, encryptedKey)
+		if err != nil || len(unwrapped) != cekSize {
+			return nil, header, jweErr("unwrapping cek", ErrJWEDecrypt)
+		}
+		cek = unwrapped
+	case JWE_ALG_RSA_OAEP_256:
+		priv, ok := key.(*rsa.PrivateKey)
+		if !ok || priv.N.BitLen() < JWE_MIN_RSA_BITS {
+			return nil, header, jweErr("alg RSA-OAEP-256", ErrJWEKeyType)
+		}
+		decrypted, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, encryptedKey, nil)
+		if err != nil || len(decrypted) != cekSize {
+			// continue with a random CEK so a bad key fails like a bad tag
+			// (RFC 7516 section 11.5), no padding oracle
+			decrypted = make([]byte, cekSize)
+			rand.Read(decrypted)
+		}
+		cek = decrypted
+	case JWE_ALG_ECDH_ES:
+		priv, err := ecdhPrivateKey(key)
+		if err != nil {
+			return nil, header, err
+		}
+		if header.Epk == nil || len(encryptedKey) != 0 {
+			return nil, header, jweErr("alg ECDH-ES", ErrJWEMalformed)
+		}
+		epk, err := ecdhFromJWK(header.Epk)
+		if err != nil {
+			return nil, header, err
+		}
+		z, err := priv.ECDH(epk)
+		if err != nil {
+			return nil, header, jweErr("key agreement", ErrJWEKeyType)
+		}
+		apu, errU := base64.RawURLEncoding.DecodeString(header.Apu)
+		apv, errV := base64.RawURLEncoding.DecodeString(header.Apv)
+		if errU != nil || errV != nil {
+			return nil, header, jweErr("decoding apu/apv", ErrJWEMalformed)
+		}
+		cek = concatKDF(z, header.Enc, apu, apv, cekSize)
+	default:
+		return nil, header, jweErr("alg "+header.Alg, ErrJWEUnsupported)
+	}
+
+	payload, err := jweOpen(header.Enc, cek, decoded[2], decoded[3], decoded[4], []byte(parts[0]))
+	if err != nil {
+		return nil, header, err
+	}
+	return payload, header, nil
+}
+
+// parseLegacyJWE reads the three-part header.nonce.ciphertext tokens of the
+// first CreateJWE version, the header is not authenticated
+func parseLegacyJWE(jweString string, key []byte) ([]byte, error) {
 	parts := strings.Split(jweString, ".")
 	if len(parts) != 3 {
 		return nil, medaerror.Errorf("invalid JWE format: %d parts", len(parts))
@@ -68,43 +344,285 @@ func ParseJWE(jweString string, key []byte) ([]byte, error) {
 
 	decodedHeader, err := base64.RawURLEncoding.DecodeString(encodedHeader)
 	if err != nil {
-		return nil, medaerror.Errorf("decoding header: %w", err)
+		return nil, jweErr("decoding header", err)
 	}
 
 	var header map[string]string
 	err = json.Unmarshal(decodedHeader, &header)
 	if err != nil {
-		return nil, medaerror.Errorf("unmarshaling header: %w", err)
+		return nil, jweErr("unmarshaling header", err)
 	}
 
 	decodedCiphertext, err := base64.RawURLEncoding.DecodeString(encodedCiphertext)
 	if err != nil {
-		return nil, medaerror.Errorf("decoding ciphertext: %w", err)
+		return nil, jweErr("decoding ciphertext", err)
 	}
 
 	decodedNonce, err := base64.RawURLEncoding.DecodeString(encodedNonce)
 	if err != nil {
-		return nil, medaerror.Errorf("decoding nonce: %w", err)
+		return nil, jweErr("decoding nonce", err)
 	}
 
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return nil, medaerror.Errorf("creating cipher: %w", err)
+		return nil, jweErr("creating cipher", err)
 	}
 
 	aesgcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return nil, medaerror.Errorf("creating GCM: %w", err)
+		return nil, jweErr("creating GCM", err)
+	}
+	if len(decodedNonce) != aesgcm.NonceSize() {
+		return nil, jweErr("decoding nonce", ErrJWEMalformed)
 	}
 
 	plaintext, err := aesgcm.Open(nil, decodedNonce, decodedCiphertext, nil)
 	if err != nil {
-		return nil, medaerror.Errorf("decrypting: %w", err)
+		return nil, jweErr("decrypting", ErrJWEDecrypt)
 	}
 
 	return plaintext, nil
 }
 
+// jweSeal encrypts with enc, aad is the ascii of the encoded protected header
+func jweSeal(enc string, cek, plaintext, aad []byte) (iv, ciphertext, tag []byte, err error) {
+	if enc == JWE_ENC_A128CBC_HS256 {
+		iv = make([]byte, aes.BlockSize)
+		if _, err := rand.Read(iv); err != nil {
+			return nil, nil, nil, jweErr("generating iv", err)
+		}
+		block, err := aes.NewCipher(cek[16:])
+		if err != nil {
+			return nil, nil, nil, jweErr("creating cipher", err)
+		}
+		padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+		padded := append(append([]byte(nil), plaintext...), make([]byte, padding)...)
+		for i := len(plaintext); i < len(padded); i++ {
+			padded[i] = byte(padding)
+		}
+		ciphertext = make([]byte, len(padded))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+		return iv, ciphertext, cbcHMACTag(cek[:16], aad, iv, ciphertext), nil
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, nil, jweErr("creating cipher", err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, nil, jweErr("creating GCM", err)
+	}
+	iv = make([]byte, aesgcm.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, nil, nil, jweErr("generating nonce", err)
+	}
+	sealed := aesgcm.Seal(nil, iv, plaintext, aad)
+	tagStart := len(sealed) - aesgcm.Overhead()
+	return iv, sealed[:tagStart], sealed[tagStart:], nil
+}
+
+func jweOpen(enc string, cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	if enc == JWE_ENC_A128CBC_HS256 {
+		if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+			return nil, jweErr("decrypting", ErrJWEMalformed)
+		}
+		if !hmac.Equal(tag, cbcHMACTag(cek[:16], aad, iv, ciphertext)) {
+			return nil, jweErr("decrypting", ErrJWEDecrypt)
+		}
+		block, err := aes.NewCipher(cek[16:])
+		if err != nil {
+			return nil, jweErr("creating cipher", err)
+		}
+		plaintext := make([]byte, len(ciphertext))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+		padding := int(plaintext[len(plaintext)-1])
+		if padding == 0 || padding > aes.BlockSize {
+			return nil, jweErr("decrypting", ErrJWEDecrypt)
+		}
+		for _, b := range plaintext[len(plaintext)-padding:] {
+			if int(b) != padding {
+				return nil, jweErr("decrypting", ErrJWEDecrypt)
+			}
+		}
+		return plaintext[:len(plaintext)-padding], nil
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, jweErr("creating cipher", err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, jweErr("creating GCM", err)
+	}
+	if len(iv) != aesgcm.NonceSize() || len(tag) != aesgcm.Overhead() {
+		return nil, jweErr("decrypting", ErrJWEMalformed)
+	}
+	plaintext, err := aesgcm.Open(nil, iv, append(append([]byte(nil), ciphertext...), tag...), aad)
+	if err != nil {
+		return nil, jweErr("decrypting", ErrJWEDecrypt)
+	}
+	return plaintext, nil
+}
+
+// cbcHMACTag is the AES_CBC_HMAC_SHA2 tag, RFC 7518 section 5.2.2.1
+func cbcHMACTag(macKey, aad, iv, ciphertext []byte) []byte {
+	mac := hmac.New(sha256.New, macKey)
+	mac.Write(aad)
+	mac.Write(iv)
+	mac.Write(ciphertext)
+	mac.Write(binary.BigEndian.AppendUint64(nil, uint64(len(aad))*8))
+	return mac.Sum(nil)[:16]
+}
+
+var aesKeyWrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// aesKeyWrap is RFC 3394 key wrap
+func aesKeyWrap(kek, cek []byte) ([]byte, error) {
+	if len(cek) < 16 || len(cek)%8 != 0 {
+		return nil, ErrJWEKeyType
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(cek) / 8
+	out := make([]byte, 8+len(cek))
+	copy(out[8:], cek)
+	a := binary.BigEndian.Uint64(aesKeyWrapIV)
+	buf := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			binary.BigEndian.PutUint64(buf, a)
+			copy(buf[8:], out[i*8:])
+			block.Encrypt(buf, buf)
+			a = binary.BigEndian.Uint64(buf) ^ uint64(n*j+i)
+			copy(out[i*8:], buf[8:])
+		}
+	}
+	binary.BigEndian.PutUint64(out, a)
+	return out, nil
+}
+
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 24 || len(wrapped)%8 != 0 {
+		return nil, ErrJWEMalformed
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	out := append([]byte(nil), wrapped...)
+	a := binary.BigEndian.Uint64(out)
+	buf := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			binary.BigEndian.PutUint64(buf, a^uint64(n*j+i))
+			copy(buf[8:], out[i*8:])
+			block.Decrypt(buf, buf)
+			a = binary.BigEndian.Uint64(buf)
+			copy(out[i*8:], buf[8:])
+		}
+	}
+	binary.BigEndian.PutUint64(out, a)
+	if subtle.ConstantTimeCompare(out[:8], aesKeyWrapIV) != 1 {
+		return nil, ErrJWEDecrypt
+	}
+	return out[8:], nil
+}
+
+// concatKDF is the single step KDF of NIST SP 800-56A with SHA-256 as used by
+// ECDH-ES (RFC 7518 section 4.6.2), alg is the enc value for direct agreement
+func concatKDF(z []byte, alg string, apu, apv []byte, size int) []byte {
+	var otherInfo []byte
+	for _, field := range [][]byte{[]byte(alg), apu, apv} {
+		otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(len(field)))
+		otherInfo = append(otherInfo, field...)
+	}
+	otherInfo = binary.BigEndian.AppendUint32(otherInfo, uint32(size*8))
+
+	var out []byte
+	for counter := uint32(1); len(out) < size; counter++ {
+		h := sha256.New()
+		h.Write(binary.BigEndian.AppendUint32(nil, counter))
+		h.Write(z)
+		h.Write(otherInfo)
+		out = h.Sum(out)
+	}
+	return out[:size]
+}
+
+var jweCurves = map[string]ecdh.Curve{
+	"P-256": ecdh.P256(),
+	"P-384": ecdh.P384(),
+	"P-521": ecdh.P521(),
+}
+
+func ecdhPublicKey(key interface{}) (*ecdh.PublicKey, error) {
+	switch k := key.(type) {
+	case *ecdh.PublicKey:
+		return k, nil
+	case *ecdsa.PublicKey:
+		pub, err := k.ECDH()
+		if err != nil {
+			return nil, jweErr("alg ECDH-ES", ErrJWEKeyType)
+		}
+		return pub, nil
+	}
+	return nil, jweErr("alg ECDH-ES", ErrJWEKeyType)
+}
+
+func ecdhPrivateKey(key interface{}) (*ecdh.PrivateKey, error) {
+	switch k := key.(type) {
+	case *ecdh.PrivateKey:
+		return k, nil
+	case *ecdsa.PrivateKey:
+		priv, err := k.ECDH()
+		if err != nil {
+			return nil, jweErr("alg ECDH-ES", ErrJWEKeyType)
+		}
+		return priv, nil
+	}
+	return nil, jweErr("alg ECDH-ES", ErrJWEKeyType)
+}
+
+func jwkFromECDH(pub *ecdh.PublicKey) (*jweJWK, error) {
+	for name, curve := range jweCurves {
+		if pub.Curve() == curve {
+			// uncompressed point: 0x04 | x | y
+			point := pub.Bytes()[1:]
+			size := len(point) / 2
+			return &jweJWK{
+				Kty: "EC",
+				Crv: name,
+				X:   base64.RawURLEncoding.EncodeToString(point[:size]),
+				Y:   base64.RawURLEncoding.EncodeToString(point[size:]),
+			}, nil
+		}
+	}
+	return nil, jweErr("ECDH-ES curve", ErrJWEUnsupported)
+}
+
+func ecdhFromJWK(jwk *jweJWK) (*ecdh.PublicKey, error) {
+	curve, ok := jweCurves[jwk.Crv]
+	if jwk.Kty != "EC" || !ok {
+		return nil, jweErr("epk curve", ErrJWEUnsupported)
+	}
+	x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+	y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+	if errX != nil || errY != nil || len(x) != len(y) {
+		return nil, jweErr("epk", ErrJWEMalformed)
+	}
+	// NewPublicKey checks the size and that the point is on the curve
+	pub, err := curve.NewPublicKey(append(append([]byte{4}, x...), y...))
+	if err != nil {
+		return nil, jweErr("epk", ErrJWEMalformed)
+	}
+	return pub, nil
+}
+
 // If the cypertext or basically the payload is json of map[string]string then this has the unmarshall
 // and return the map[string]string
 // NOTE: later if needed use MapToStruct from utils
@@ -116,7 +634,7 @@ func ParseJWEToMap(jweString string, key []byte) (map[string]string, error) {
 	var tmpMap map[string]string
 	err = json.Unmarshal(plainText, &tmpMap)
 	if err != nil {
-		return nil, medaerror.Errorf("unmarshaling jwe payload: %w", err)
+		return nil, jweErr("unmarshaling jwe payload", err)
 	}
 	return tmpMap, nil
 }
@@ -0,0 +1,145 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestJWERoundTrip(t *testing.T) {
+	key32 := []byte(aeadTestKey)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recipients := []struct {
+		alg     string
+		encrypt interface{}
+		decrypt interface{}
+	}{
+		{JWE_ALG_DIR, key32, key32},
+		{JWE_ALG_A256KW, key32, key32},
+		{JWE_ALG_RSA_OAEP_256, &rsaKey.PublicKey, rsaKey},
+		{JWE_ALG_ECDH_ES, &ecKey.PublicKey, ecKey},
+	}
+	for _, r := range recipients {
+		for _, enc := range []string{JWE_ENC_A256GCM, JWE_ENC_A128CBC_HS256} {
+			token, err := EncryptJWE([]byte(`{"sub":"42"}`), r.encrypt, JWEOptions{Alg: r.alg, Enc: enc, Kid: "k1"})
+			if err != nil {
+				t.Fatalf("%s/%s: %v", r.alg, enc, err)
+			}
+			if n := strings.Count(token, "."); n != 4 {
+				t.Fatalf("%s/%s: %d dots, want compact five-part token", r.alg, enc, n)
+			}
+			payload, header, err := DecryptJWE(token, r.decrypt)
+			if err != nil || string(payload) != `{"sub":"42"}` {
+				t.Fatalf("%s/%s: payload = %q, err = %v", r.alg, enc, payload, err)
+			}
+			if header.Alg != r.alg || header.Enc != enc || header.Kid != "k1" {
+				t.Fatalf("%s/%s: header = %+v", r.alg, enc, header)
+			}
+
+			// the protected header is the AAD, changing it breaks the tag
+			parts := strings.Split(token, ".")
+			raw, _ := base64.RawURLEncoding.DecodeString(parts[0])
+			parts[0] = base64.RawURLEncoding.EncodeToString(bytes.Replace(raw, []byte(`"k1"`), []byte(`"k2"`), 1))
+			if _, _, err := DecryptJWE(strings.Join(parts, "."), r.decrypt); !errors.Is(err, ErrJWEDecrypt) {
+				t.Fatalf("%s/%s: tampered header err = %v", r.alg, enc, err)
+			}
+		}
+	}
+
+	// the key type pins the algorithm
+	token, _ := EncryptJWE([]byte("x"), &rsaKey.PublicKey, JWEOptions{Alg: JWE_ALG_RSA_OAEP_256})
+	if _, _, err := DecryptJWE(token, key32); !errors.Is(err, ErrJWEKeyType) {
+		t.Fatalf("rsa token with symmetric key err = %v", err)
+	}
+}
+
+func TestCreateParseJWE(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	token, err := CreateJWE([]byte("hello"), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, header, err := DecryptJWE(token, key)
+	if err != nil || header.Alg != JWE_ALG_DIR || header.Enc != JWE_ENC_A128GCM {
+		t.Fatalf("header = %+v, err = %v", header, err)
+	}
+	if payload, err := ParseJWE(token, key); err != nil || string(payload) != "hello" {
+		t.Fatalf("ParseJWE = %q, %v", payload, err)
+	}
+	if _, err := ParseJWE(token, []byte("fedcba9876543210")); !errors.Is(err, ErrJWEDecrypt) {
+		t.Fatalf("wrong key err = %v", err)
+	}
+}
+
+func TestParseLegacyJWE(t *testing.T) {
+	// header.nonce.ciphertext as made by the first CreateJWE
+	key := []byte("0123456789abcdef")
+	block, _ := aes.NewCipher(key)
+	aesgcm, _ := cipher.NewGCM(block)
+	nonce := make([]byte, aesgcm.NonceSize())
+	legacy := strings.Join([]string{
+		base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"A128GCM","enc":"A128GCM"}`)),
+		base64.RawURLEncoding.EncodeToString(nonce),
+		base64.RawURLEncoding.EncodeToString(aesgcm.Seal(nil, nonce, []byte(`{"a":"b"}`), nil)),
+	}, ".")
+	m, err := ParseJWEToMap(legacy, key)
+	if err != nil || m["a"] != "b" {
+		t.Fatalf("legacy = %v, %v", m, err)
+	}
+}
+
+func TestAESKeyWrapRFC3394(t *testing.T) {
+	// RFC 3394 section 4.6, 128 bits of key data with a 256 bit KEK
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	cek, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+	want, _ := hex.DecodeString("64E8C3F9CE0F5BA263E9777905818A2A93C8191E7D6E8AE7")
+	wrapped, err := aesKeyWrap(kek, cek)
+	if err != nil || !bytes.Equal(wrapped, want) {
+		t.Fatalf("wrap = %X, %v", wrapped, err)
+	}
+	unwrapped, err := aesKeyUnwrap(kek, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, cek) {
+		t.Fatalf("unwrap = %X, %v", unwrapped, err)
+	}
+	wrapped[0] ^= 1
+	if _, err := aesKeyUnwrap(kek, wrapped); !errors.Is(err, ErrJWEDecrypt) {
+		t.Fatalf("tampered unwrap err = %v", err)
+	}
+}
+
+func TestCBCHMACTagRFC7518(t *testing.T) {
+	// RFC 7518 appendix B.1, AES_128_CBC_HMAC_SHA_256
+	key, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	iv, _ := hex.DecodeString("1af38c2dc2b96ffdd86694092341bc04")
+	plaintext := []byte("A cipher system must not be required to be secret, and it must be able to fall into the hands of the enemy without inconvenience")
+	aad := []byte("The second principle of Auguste Kerckhoffs")
+	padding := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append(plaintext, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	block, _ := aes.NewCipher(key[16:])
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	if tag := hex.EncodeToString(cbcHMACTag(key[:16], aad, iv, ciphertext)); tag != "652c3fa36b0a7c5b3219fab3a30bc1c4" {
+		t.Fatalf("tag = %s", tag)
+	}
+	got, err := jweOpen(JWE_ENC_A128CBC_HS256, key, iv, ciphertext, cbcHMACTag(key[:16], aad, iv, ciphertext), aad)
+	if err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("open = %q, %v", got, err)
+	}
+}
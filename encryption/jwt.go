@@ -30,8 +30,14 @@ func GetAuthorizationFromHeader(authstring string) (string, string) {
 // Get JWT Claim manually (without using the JWT middleware)
 // Parse the header manually then get the JWT. This function is needed to check
 // if JWT is valid but expired, then we use it to renew/extends the expiration
+// For new code use JWTManager and ParseJWT, they check iss/aud/exp and return
+// distinct errors.
 func GetJWTClaimMapFromTokenString(t, JWTKey string) (jwt.MapClaims, error) {
 	oldToken, err := jwt.Parse(t, func(token *jwt.Token) (interface{}, error) {
+		// JWTKey is an HMAC secret, never let the token pick another algorithm
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, ErrJWTAlgorithm
+		}
 		return []byte(JWTKey), nil
 	})
 	if err != nil {
@@ -0,0 +1,323 @@
+package encryption
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/medatechnology/goutil/medaerror"
+)
+
+// JWTManager issues and validates JWTs with one algorithm and key. Only that
+// algorithm is accepted when parsing (no "none", no RS256 token verified with
+// the HMAC secret), exp/nbf are checked with a clock skew and iss/aud against
+// the configured values.
+//
+// Usage:
+//
+//	m, err := encryption.NewJWTManager(encryption.JWT_ALG_ES256, ecdsaPrivateKey)
+//	m.SetIssuer("auth.example.com").SetAudience("api").SetTTL(time.Hour)
+//
+//	type UserClaims struct {
+//		Role string `json:"role"`
+//	}
+//	claims := encryption.Claims[UserClaims]{Custom: UserClaims{Role: "admin"}}
+//	claims.Subject = "42"
+//	token, err := encryption.IssueJWT(m, claims) // iss, aud, iat and exp are filled in
+//
+//	parsed, err := encryption.ParseJWT[UserClaims](m, token)
+//	fmt.Println(parsed.Subject, parsed.Custom.Role)
+//
+//	var merr medaerror.MedaError
+//	if errors.As(err, &merr) && merr.Code == encryption.JWT_EXPIRED_ERROR { ... refresh ... }
+//	if errors.Is(err, encryption.ErrJWTExpired) { ... same ... }
+//
+// Keys: HS256 []byte (at least 32 bytes), RS256 *rsa.PrivateKey, ES256
+// *ecdsa.PrivateKey (P-256), EdDSA ed25519.PrivateKey. With the public key
+// (*rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey) the manager can only
+// verify. Custom claims are written next to the registered claims in the same
+// json object, ParseJWT[map[string]interface{}] gives every claim as a map.
+
+const (
+	JWT_ALG_HS256 = "HS256"
+	JWT_ALG_RS256 = "RS256"
+	JWT_ALG_ES256 = "ES256"
+	JWT_ALG_EDDSA = "EdDSA"
+
+	DEFAULT_JWT_TTL        = 15 * time.Minute
+	DEFAULT_JWT_CLOCK_SKEW = 30 * time.Second
+	JWT_MIN_HMAC_KEY_SIZE  = 32
+
+	// medaerror codes of ParseJWT
+	JWT_MALFORMED_ERROR      = 1400 // not a jwt, bad encoding or bad json
+	JWT_BAD_SIGNATURE_ERROR  = 1401 // signature does not verify or the algorithm is not allowed
+	JWT_INVALID_CLAIMS_ERROR = 1403 // missing exp, wrong iss or aud
+	JWT_NOT_YET_VALID_ERROR  = 1425 // nbf is in the future
+	JWT_EXPIRED_ERROR        = 1440 // exp has passed
+)
+
+var (
+	ErrJWTMalformed    = errors.New("jwt: malformed token")
+	ErrJWTSignature    = errors.New("jwt: invalid signature")
+	ErrJWTAlgorithm    = fmt.Errorf("%w: algorithm not allowed", ErrJWTSignature)
+	ErrJWTClaims       = errors.New("jwt: invalid claims")
+	ErrJWTNotYetValid  = errors.New("jwt: token not valid yet")
+	ErrJWTExpired      = errors.New("jwt: token expired")
+	ErrJWTKey          = errors.New("jwt: key does not fit the algorithm")
+	ErrJWTVerifyOnly   = errors.New("jwt: manager has no signing key")
+	ErrJWTCustomClaims = errors.New("jwt: custom claims must encode to a json object")
+)
+
+type JWTManager struct {
+	method     jwt.SigningMethod
+	signKey    interface{} // nil for verify only
+	verifyKey  interface{}
+	keyID      string
+	issuer     string
+	audience   []string
+	ttl        time.Duration
+	skew       time.Duration
+	requireExp bool
+	now        func() time.Time
+}
+
+// NewJWTManager creates a manager for alg (JWT_ALG_*), see the file comment for
+// the key types
+func NewJWTManager(alg string, key interface{}) (*JWTManager, error) {
+	m := &JWTManager{ttl: DEFAULT_JWT_TTL, skew: DEFAULT_JWT_CLOCK_SKEW, requireExp: true, now: time.Now}
+	switch alg {
+	case JWT_ALG_HS256:
+		k, ok := key.([]byte)
+		if !ok || len(k) < JWT_MIN_HMAC_KEY_SIZE {
+			return nil, fmt.Errorf("%w: HS256 needs a []byte of at least %d bytes", ErrJWTKey, JWT_MIN_HMAC_KEY_SIZE)
+		}
+		m.method, m.signKey, m.verifyKey = jwt.SigningMethodHS256, k, k
+	case JWT_ALG_RS256:
+		m.method = jwt.SigningMethodRS256
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			m.signKey, m.verifyKey = k, &k.PublicKey
+		case *rsa.PublicKey:
+			m.verifyKey = k
+		}
+		if pub, ok := m.verifyKey.(*rsa.PublicKey); !ok || pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%w: RS256 needs a 2048 bit or bigger rsa key", ErrJWTKey)
+		}
+	case JWT_ALG_ES256:
+		m.method = jwt.SigningMethodES256
+		switch k := key.(type) {
+		case *ecdsa.PrivateKey:
+			m.signKey, m.verifyKey = k, &k.PublicKey
+		case *ecdsa.PublicKey:
+			m.verifyKey = k
+		}
+		if pub, ok := m.verifyKey.(*ecdsa.PublicKey); !ok || pub.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: ES256 needs a P-256 ecdsa key", ErrJWTKey)
+		}
+	case JWT_ALG_EDDSA:
+		m.method = jwt.SigningMethodEdDSA
+		switch k := key.(type) {
+		case ed25519.PrivateKey:
+			if len(k) == ed25519.PrivateKeySize {
+				m.signKey, m.verifyKey = k, k.Public()
+			}
+		case ed25519.PublicKey:
+			if len(k) == ed25519.PublicKeySize {
+				m.verifyKey = k
+			}
+		}
+		if m.verifyKey == nil {
+			return nil, fmt.Errorf("%w: EdDSA needs an ed25519 key", ErrJWTKey)
+		}
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrJWTKey, alg)
+	}
+	return m, nil
+}
+
+// Algorithm is the only alg this manager signs and accepts
+func (m *JWTManager) Algorithm() string {
+	return m.method.Alg()
+}
+
+// SetKeyID writes kid in the header of issued tokens
+func (m *JWTManager) SetKeyID(kid string) *JWTManager {
+	m.keyID = kid
+	return m
+}
+
+// SetIssuer is set as iss when issuing and required when parsing
+func (m *JWTManager) SetIssuer(issuer string) *JWTManager {
+	m.issuer = issuer
+	return m
+}
+
+// SetAudience is set as aud when issuing, when parsing the token must have at
+// least one of them
+func (m *JWTManager) SetAudience(audience ...string) *JWTManager {
+	m.audience = audience
+	return m
+}
+
+// SetTTL is the lifetime of issued tokens without exp, 0 issues tokens without exp
+func (m *JWTManager) SetTTL(ttl time.Duration) *JWTManager {
+	m.ttl = ttl
+	return m
+}
+
+// SetClockSkew is the tolerance for exp and nbf between servers
+func (m *JWTManager) SetClockSkew(skew time.Duration) *JWTManager {
+	m.skew = skew
+	return m
+}
+
+// SetRequireExpiry rejects tokens without exp when true (default)
+func (m *JWTManager) SetRequireExpiry(require bool) *JWTManager {
+	m.requireExp = require
+	return m
+}
+
+// Claims is the registered claims plus custom claims T (a struct or map that
+// encodes to a json object), both are in the same json object
+type Claims[T any] struct {
+	jwt.RegisteredClaims
+	Custom T `json:"-"`
+}
+
+func (c Claims[T]) MarshalJSON() ([]byte, error) {
+	merged := map[string]json.RawMessage{}
+	custom, err := json.Marshal(c.Custom)
+	if err != nil {
+		return nil, err
+	}
+	if string(custom) != "null" {
+		if err := json.Unmarshal(custom, &merged); err != nil {
+			return nil, ErrJWTCustomClaims
+		}
+	}
+	registered, err := json.Marshal(c.RegisteredClaims)
+	if err != nil {
+		return nil, err
+	}
+	var reg map[string]json.RawMessage
+	if err := json.Unmarshal(registered, &reg); err != nil {
+		return nil, err
+	}
+	// the registered claims win over custom fields with the same name
+	for k, v := range reg {
+		merged[k] = v
+	}
+	return json.Marshal(merged)
+}
+
+func (c *Claims[T]) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &c.RegisteredClaims); err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &c.Custom)
+}
+
+// Sign signs any claims as they are, see IssueJWT for the defaults
+func (m *JWTManager) Sign(claims jwt.Claims) (string, error) {
+	if m.signKey == nil {
+		return "", ErrJWTVerifyOnly
+	}
+	token := jwt.NewWithClaims(m.method, claims)
+	if m.keyID != "" {
+		token.Header["kid"] = m.keyID
+	}
+	return token.SignedString(m.signKey)
+}
+
+// IssueJWT fills iss, aud, iat and exp (from the manager, when not set) and
+// signs the claims
+func IssueJWT[T any](m *JWTManager, claims Claims[T]) (string, error) {
+	now := m.now()
+	if claims.Issuer == "" {
+		claims.Issuer = m.issuer
+	}
+	if len(claims.Audience) == 0 && len(m.audience) > 0 {
+		claims.Audience = append(jwt.ClaimStrings(nil), m.audience...)
+	}
+	if claims.IssuedAt == nil {
+		claims.IssuedAt = jwt.NewNumericDate(now)
+	}
+	if claims.ExpiresAt == nil && m.ttl > 0 {
+		claims.ExpiresAt = jwt.NewNumericDate(now.Add(m.ttl))
+	}
+	return m.Sign(claims)
+}
+
+// ParseJWT verifies the signature and the claims and decodes them. Errors are
+// medaerror.MedaError with one of the JWT_*_ERROR codes, errors.Is works with
+// the ErrJWT* values.
+func ParseJWT[T any](m *JWTManager, token string) (*Claims[T], error) {
+	claims := &Claims[T]{}
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	_, err := parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Method.Alg() != m.method.Alg() {
+			return nil, ErrJWTAlgorithm
+		}
+		return m.verifyKey, nil
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrJWTAlgorithm):
+			return nil, jwtError(JWT_BAD_SIGNATURE_ERROR, ErrJWTAlgorithm, "invalid token")
+		case errors.Is(err, jwt.ErrTokenSignatureInvalid), errors.Is(err, jwt.ErrTokenUnverifiable):
+			// unverifiable is an alg name the library does not know
+			return nil, jwtError(JWT_BAD_SIGNATURE_ERROR, ErrJWTSignature, "invalid token")
+		default:
+			return nil, jwtError(JWT_MALFORMED_ERROR, ErrJWTMalformed, "invalid token")
+		}
+	}
+	if err := m.validate(&claims.RegisteredClaims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (m *JWTManager) validate(c *jwt.RegisteredClaims) error {
+	now := m.now()
+	if c.ExpiresAt == nil && m.requireExp {
+		return jwtError(JWT_INVALID_CLAIMS_ERROR, ErrJWTClaims, "invalid token")
+	}
+	if c.ExpiresAt != nil && !now.Before(c.ExpiresAt.Add(m.skew)) {
+		return jwtError(JWT_EXPIRED_ERROR, ErrJWTExpired, "token expired")
+	}
+	if c.NotBefore != nil && now.Add(m.skew).Before(c.NotBefore.Time) {
+		return jwtError(JWT_NOT_YET_VALID_ERROR, ErrJWTNotYetValid, "token not valid yet")
+	}
+	if m.issuer != "" && c.Issuer != m.issuer {
+		return jwtError(JWT_INVALID_CLAIMS_ERROR, ErrJWTClaims, "invalid token")
+	}
+	if len(m.audience) > 0 && !audienceMatches(c.Audience, m.audience) {
+		return jwtError(JWT_INVALID_CLAIMS_ERROR, ErrJWTClaims, "invalid token")
+	}
+	return nil
+}
+
+func audienceMatches(got jwt.ClaimStrings, want []string) bool {
+	for _, g := range got {
+		for _, w := range want {
+			if g == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwtError is a MedaError with code, response for the API caller and the
+// sentinel for errors.Is
+func jwtError(code int, sentinel error, response string) error {
+	err := medaerror.NewMedaErr(code, sentinel.Error(), response, nil)
+	err.Err = sentinel
+	return err
+}
@@ -0,0 +1,161 @@
+package encryption
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/medatechnology/goutil/medaerror"
+)
+
+type testUserClaims struct {
+	Role string `json:"role"`
+}
+
+func testJWTManagers(t *testing.T) map[string]*JWTManager {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	keys := map[string]interface{}{
+		JWT_ALG_HS256: []byte(aeadTestKey),
+		JWT_ALG_RS256: rsaKey,
+		JWT_ALG_ES256: ecKey,
+		JWT_ALG_EDDSA: edKey,
+	}
+	managers := map[string]*JWTManager{}
+	for alg, key := range keys {
+		m, err := NewJWTManager(alg, key)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		managers[alg] = m.SetIssuer("auth").SetAudience("api")
+	}
+	return managers
+}
+
+func jwtErrorCode(err error) int {
+	var merr medaerror.MedaError
+	if errors.As(err, &merr) {
+		return merr.Code
+	}
+	return 0
+}
+
+func TestJWTManagerIssueParse(t *testing.T) {
+	for alg, m := range testJWTManagers(t) {
+		claims := Claims[testUserClaims]{Custom: testUserClaims{Role: "admin"}}
+		claims.Subject = "42"
+		token, err := IssueJWT(m, claims)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		parsed, err := ParseJWT[testUserClaims](m, token)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		if parsed.Subject != "42" || parsed.Custom.Role != "admin" || parsed.Issuer != "auth" || parsed.ExpiresAt == nil {
+			t.Fatalf("%s: claims = %+v", alg, parsed)
+		}
+
+		all, err := ParseJWT[map[string]interface{}](m, token)
+		if err != nil || all.Custom["role"] != "admin" || all.Custom["sub"] != "42" {
+			t.Fatalf("%s: map claims = %v, %v", alg, all, err)
+		}
+
+		// flip a signature byte
+		parts := strings.Split(token, ".")
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sig[0] ^= 1
+		tampered := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(sig)
+		if _, err := ParseJWT[testUserClaims](m, tampered); jwtErrorCode(err) != JWT_BAD_SIGNATURE_ERROR || !errors.Is(err, ErrJWTSignature) {
+			t.Fatalf("%s: tampered err = %v", alg, err)
+		}
+	}
+}
+
+func TestJWTManagerPinsAlgorithm(t *testing.T) {
+	managers := testJWTManagers(t)
+	hs := managers[JWT_ALG_HS256]
+
+	rsToken, _ := IssueJWT(managers[JWT_ALG_RS256], Claims[testUserClaims]{})
+	if _, err := ParseJWT[testUserClaims](hs, rsToken); !errors.Is(err, ErrJWTAlgorithm) || jwtErrorCode(err) != JWT_BAD_SIGNATURE_ERROR {
+		t.Fatalf("RS256 token on HS256 manager err = %v", err)
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.RegisteredClaims{ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))})
+	noneToken, _ := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if _, err := ParseJWT[testUserClaims](hs, noneToken); !errors.Is(err, ErrJWTAlgorithm) {
+		t.Fatalf("alg none err = %v", err)
+	}
+
+	if _, err := ParseJWT[testUserClaims](hs, "not.a.jwt"); jwtErrorCode(err) != JWT_MALFORMED_ERROR || !errors.Is(err, ErrJWTMalformed) {
+		t.Fatalf("malformed err = %v", err)
+	}
+}
+
+func TestJWTManagerTimeAndClaims(t *testing.T) {
+	m := testJWTManagers(t)[JWT_ALG_HS256].SetClockSkew(time.Minute).SetTTL(time.Hour)
+	issued := time.Unix(1_700_000_000, 0)
+	m.now = func() time.Time { return issued }
+	token, _ := IssueJWT(m, Claims[testUserClaims]{})
+
+	// still fine inside the skew
+	m.now = func() time.Time { return issued.Add(time.Hour + 30*time.Second) }
+	if _, err := ParseJWT[testUserClaims](m, token); err != nil {
+		t.Fatalf("inside skew err = %v", err)
+	}
+	m.now = func() time.Time { return issued.Add(time.Hour + 2*time.Minute) }
+	if _, err := ParseJWT[testUserClaims](m, token); jwtErrorCode(err) != JWT_EXPIRED_ERROR || !errors.Is(err, ErrJWTExpired) {
+		t.Fatalf("expired err = %v", err)
+	}
+
+	m.now = func() time.Time { return issued }
+	early := Claims[testUserClaims]{}
+	early.NotBefore = jwt.NewNumericDate(issued.Add(10 * time.Minute))
+	token, _ = IssueJWT(m, early)
+	if _, err := ParseJWT[testUserClaims](m, token); jwtErrorCode(err) != JWT_NOT_YET_VALID_ERROR {
+		t.Fatalf("nbf err = %v", err)
+	}
+
+	other := Claims[testUserClaims]{}
+	other.Audience = jwt.ClaimStrings{"billing"}
+	token, _ = IssueJWT(m, other)
+	if _, err := ParseJWT[testUserClaims](m, token); jwtErrorCode(err) != JWT_INVALID_CLAIMS_ERROR || !errors.Is(err, ErrJWTClaims) {
+		t.Fatalf("audience err = %v", err)
+	}
+
+	noExp, _ := m.Sign(jwt.RegisteredClaims{Issuer: "auth", Audience: jwt.ClaimStrings{"api"}})
+	if _, err := ParseJWT[testUserClaims](m, noExp); !errors.Is(err, ErrJWTClaims) {
+		t.Fatalf("missing exp err = %v", err)
+	}
+}
+
+func TestNewJWTManagerKeys(t *testing.T) {
+	if _, err := NewJWTManager(JWT_ALG_HS256, []byte("short")); !errors.Is(err, ErrJWTKey) {
+		t.Fatalf("short hmac key err = %v", err)
+	}
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if _, err := NewJWTManager(JWT_ALG_RS256, ecKey); !errors.Is(err, ErrJWTKey) {
+		t.Fatalf("ecdsa key for RS256 err = %v", err)
+	}
+	verifier, err := NewJWTManager(JWT_ALG_ES256, &ecKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := IssueJWT(verifier, Claims[testUserClaims]{}); !errors.Is(err, ErrJWTVerifyOnly) {
+		t.Fatalf("verify only issue err = %v", err)
+	}
+	signer, _ := NewJWTManager(JWT_ALG_ES256, ecKey)
+	token, _ := IssueJWT(signer, Claims[testUserClaims]{})
+	if _, err := ParseJWT[testUserClaims](verifier, token); err != nil {
+		t.Fatalf("verify with public key err = %v", err)
+	}
+}
@@ -0,0 +1,520 @@
+package encryption
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Keyring keeps named, versioned keys instead of raw key strings. Encrypt uses
+// envelope encryption: every message gets a random data key (DEK) that is
+// wrapped by a key encryption key (KEK) derived from the newest version of the
+// named key. The key name and version are written in the ciphertext header, so
+// after Rotate old ciphertexts still decrypt with their own version and new
+// ones use the new version.
+//
+// Usage:
+//
+//	store := encryption.NewFileKeyStore("/etc/myapp/keys.json", masterKey) // masterKey may be nil
+//	kr, err := encryption.NewKeyring(store)
+//	kr.AddKey("users") // once, creates users v1
+//
+//	blob, err := kr.Encrypt("users", []byte("secret"), []byte("user:42"))
+//	plain, err := kr.Decrypt(blob, []byte("user:42"))
+//
+//	kr.Rotate("users")                             // users v2 for new data, v1 still decrypts
+//	blob, err = kr.Rewrap(blob, []byte("user:42")) // move an old blob to v2
+//
+//	// a key per purpose, ie: for a HMAC or EncryptAEAD. Keep the version with
+//	// the data, after Rotate DeriveKey gives a new subkey and the old one is
+//	// DeriveKeyVersion("users", version, "session-mac")
+//	macKey, version, err := kr.DeriveKey("users", "session-mac")
+//
+// Ciphertext: version (1 byte) | name length (1 byte) | name | key version (4
+// bytes) | wrapped DEK length (2 bytes) | wrapped DEK (Seal envelope) | data
+// (Seal envelope). The header is authenticated with both envelopes.
+
+const (
+	KEYRING_VERSION_1 byte = 1
+	KEYRING_KEK_INFO       = "goutil keyring kek"
+	KEYRING_FILE_MODE      = 0600
+)
+
+var (
+	ErrKeyNotFound                = errors.New("encryption: key not found")
+	ErrKeyExists                  = errors.New("encryption: key already exists")
+	ErrInvalidKeyringCiphertext   = errors.New("encryption: invalid keyring ciphertext")
+	ErrUnknownKeyringVersion      = errors.New("encryption: unknown keyring ciphertext version")
+	ErrInvalidKeyName             = errors.New("encryption: key name must be 1 to 255 bytes")
+	ErrKeyringStoreNeedsMasterKey = errors.New("encryption: key store is sealed, master key required")
+)
+
+// KeyVersion is one version of a named key
+type KeyVersion struct {
+	Name    string
+	Version uint32
+	Key     []byte // AEAD_KEY_SIZE bytes
+	Created time.Time
+}
+
+// ID is "name/v<version>", ie: "users/v2"
+func (k KeyVersion) ID() string {
+	return fmt.Sprintf("%s/v%d", k.Name, k.Version)
+}
+
+// KeyStore persists the keys of a Keyring
+type KeyStore interface {
+	Load() ([]KeyVersion, error)
+	Save(keys []KeyVersion) error
+}
+
+type Keyring struct {
+	mu    sync.RWMutex
+	store KeyStore
+	keys  map[string][]KeyVersion // sorted by version, last one is current
+	now   func() time.Time
+}
+
+// NewKeyring loads the keys from store, store may be nil for a keyring that
+// lives only in memory
+func NewKeyring(store KeyStore) (*Keyring, error) {
+	k := &Keyring{store: store, keys: map[string][]KeyVersion{}, now: time.Now}
+	if store == nil {
+		return k, nil
+	}
+	keys, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	for _, key := range keys {
+		if len(key.Key) != AEAD_KEY_SIZE {
+			return nil, fmt.Errorf("encryption: key %s: %w", key.ID(), ErrAEADKeySize)
+		}
+		k.keys[key.Name] = append(k.keys[key.Name], key)
+	}
+	for _, versions := range k.keys {
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+	}
+	return k, nil
+}
+
+// AddKey creates version 1 of a new random key
+func (k *Keyring) AddKey(name string) (KeyVersion, error) {
+	key, err := NewAEADKey()
+	if err != nil {
+		return KeyVersion{}, err
+	}
+	return k.ImportKey(name, key)
+}
+
+// ImportKey adds an existing 32 byte key as version 1 of name
+func (k *Keyring) ImportKey(name string, key []byte) (KeyVersion, error) {
+	if len(name) == 0 || len(name) > 255 {
+		return KeyVersion{}, ErrInvalidKeyName
+	}
+	if len(key) != AEAD_KEY_SIZE {
+		return KeyVersion{}, ErrAEADKeySize
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, ok := k.keys[name]; ok {
+		return KeyVersion{}, ErrKeyExists
+	}
+	return k.addVersion(name, 1, append([]byte(nil), key...))
+}
+
+// Rotate adds a new random version of name, it becomes the current version
+func (k *Keyring) Rotate(name string) (KeyVersion, error) {
+	key, err := NewAEADKey()
+	if err != nil {
+		return KeyVersion{}, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	versions, ok := k.keys[name]
+	if !ok {
+		return KeyVersion{}, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	return k.addVersion(name, versions[len(versions)-1].Version+1, key)
+}
+
+// addVersion needs k.mu, the keyring is unchanged when saving fails
+func (k *Keyring) addVersion(name string, version uint32, key []byte) (KeyVersion, error) {
+	kv := KeyVersion{Name: name, Version: version, Key: key, Created: k.now().UTC()}
+	versions := append(append([]KeyVersion(nil), k.keys[name]...), kv)
+	if k.store != nil {
+		all := []KeyVersion{}
+		for n, vs := range k.keys {
+			if n != name {
+				all = append(all, vs...)
+			}
+		}
+		if err := k.store.Save(append(all, versions...)); err != nil {
+			return KeyVersion{}, err
+		}
+	}
+	k.keys[name] = versions
+	return kv, nil
+}
+
+// Current is the newest version of name
+func (k *Keyring) Current(name string) (KeyVersion, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	versions, ok := k.keys[name]
+	if !ok {
+		return KeyVersion{}, fmt.Errorf("%w: %s", ErrKeyNotFound, name)
+	}
+	return versions[len(versions)-1], nil
+}
+
+// Get returns one version of name
+func (k *Keyring) Get(name string, version uint32) (KeyVersion, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	for _, kv := range k.keys[name] {
+		if kv.Version == version {
+			return kv, nil
+		}
+	}
+	return KeyVersion{}, fmt.Errorf("%w: %s/v%d", ErrKeyNotFound, name, version)
+}
+
+// Names lists the key names, sorted
+func (k *Keyring) Names() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	names := make([]string, 0, len(k.keys))
+	for name := range k.keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// DeriveKey derives a 32 byte subkey for purpose from the current version of
+// name (HKDF-SHA256) and returns that version. The subkey changes on Rotate,
+// store the version next to data protected with it and derive the old subkey
+// again with DeriveKeyVersion.
+func (k *Keyring) DeriveKey(name, purpose string) ([]byte, uint32, error) {
+	kv, err := k.Current(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	subkey, err := deriveKeyringSubkey(kv, purpose)
+	if err != nil {
+		return nil, 0, err
+	}
+	return subkey, kv.Version, nil
+}
+
+// DeriveKeyVersion is DeriveKey with one version of name. The same name,
+// version and purpose always give the same subkey.
+func (k *Keyring) DeriveKeyVersion(name string, version uint32, purpose string) ([]byte, error) {
+	kv, err := k.Get(name, version)
+	if err != nil {
+		return nil, err
+	}
+	return deriveKeyringSubkey(kv, purpose)
+}
+
+func deriveKeyringSubkey(kv KeyVersion, purpose string) ([]byte, error) {
+	if purpose == KEYRING_KEK_INFO {
+		return nil, fmt.Errorf("encryption: purpose %q is reserved", purpose)
+	}
+	return DeriveSubkey(kv.Key, purpose, AEAD_KEY_SIZE)
+}
+
+// DeriveSubkey derives a subkey of size bytes from a master key with HKDF-SHA256,
+// purpose separates the subkeys (ie: "session-mac", "db-column:email")
+func DeriveSubkey(master []byte, purpose string, size int) ([]byte, error) {
+	subkey := make([]byte, size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, master, nil, []byte(purpose)), subkey); err != nil {
+		return nil, err
+	}
+	return subkey, nil
+}
+
+// Encrypt encrypts plaintext under the current version of name, aad (may be
+// nil) must be given again to Decrypt
+func (k *Keyring) Encrypt(name string, plaintext, aad []byte) ([]byte, error) {
+	kv, err := k.Current(name)
+	if err != nil {
+		return nil, err
+	}
+	dek, err := NewAEADKey()
+	if err != nil {
+		return nil, err
+	}
+	header := keyringHeader(kv)
+	data, err := Seal(AES256GCM, dek, plaintext, append(header, aad...))
+	if err != nil {
+		return nil, err
+	}
+	return k.wrap(kv, header, dek, data)
+}
+
+// Decrypt decrypts the output of Encrypt, with whatever key version it was made
+func (k *Keyring) Decrypt(ciphertext, aad []byte) ([]byte, error) {
+	header, dek, data, err := k.unwrap(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return Open(dek, data, append(header, aad...))
+}
+
+// Rewrap re-encrypts a ciphertext under the current version of its key, use
+// it after Rotate (see NeedsRewrap). The aad must match the original.
+func (k *Keyring) Rewrap(ciphertext, aad []byte) ([]byte, error) {
+	plaintext, err := k.Decrypt(ciphertext, aad)
+	if err != nil {
+		return nil, err
+	}
+	name, _, err := CiphertextKeyID(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	return k.Encrypt(name, plaintext, aad)
+}
+
+// CiphertextKeyID reads the key name and version from the header of a
+// ciphertext made by Encrypt
+func CiphertextKeyID(ciphertext []byte) (name string, version uint32, err error) {
+	header, _, err := splitKeyringHeader(ciphertext)
+	if err != nil {
+		return "", 0, err
+	}
+	nameLen := int(header[1])
+	return string(header[2 : 2+nameLen]), binary.BigEndian.Uint32(header[2+nameLen:]), nil
+}
+
+// NeedsRewrap is true when the ciphertext was not made with the current
+// version of its key
+func (k *Keyring) NeedsRewrap(ciphertext []byte) (bool, error) {
+	name, version, err := CiphertextKeyID(ciphertext)
+	if err != nil {
+		return false, err
+	}
+	current, err := k.Current(name)
+	if err != nil {
+		return false, err
+	}
+	return version != current.Version, nil
+}
+
+func keyringKEK(kv KeyVersion) ([]byte, error) {
+	return DeriveSubkey(kv.Key, KEYRING_KEK_INFO, AEAD_KEY_SIZE)
+}
+
+func (k *Keyring) wrap(kv KeyVersion, header, dek, data []byte) ([]byte, error) {
+	kek, err := keyringKEK(kv)
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := Seal(AES256GCM, kek, dek, header)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, len(header)+2+len(wrapped)+len(data))
+	out = append(out, header...)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+	return append(out, data...), nil
+}
+
+func (k *Keyring) unwrap(ciphertext []byte) (header, dek, data []byte, err error) {
+	header, rest, err := splitKeyringHeader(ciphertext)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	name, version, _ := CiphertextKeyID(ciphertext)
+	kv, err := k.Get(name, version)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(rest) < 2 {
+		return nil, nil, nil, ErrInvalidKeyringCiphertext
+	}
+	wrappedLen := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+wrappedLen {
+		return nil, nil, nil, ErrInvalidKeyringCiphertext
+	}
+	kek, err := keyringKEK(kv)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dek, err = Open(kek, rest[2:2+wrappedLen], header)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// copy so the caller's aad appended to header cannot write into ciphertext
+	return append([]byte(nil), header...), dek, rest[2+wrappedLen:], nil
+}
+
+func keyringHeader(kv KeyVersion) []byte {
+	header := make([]byte, 0, 2+len(kv.Name)+4)
+	header = append(header, KEYRING_VERSION_1, byte(len(kv.Name)))
+	header = append(header, kv.Name...)
+	return binary.BigEndian.AppendUint32(header, kv.Version)
+}
+
+func splitKeyringHeader(ciphertext []byte) (header, rest []byte, err error) {
+	if len(ciphertext) < 2 {
+		return nil, nil, ErrInvalidKeyringCiphertext
+	}
+	if ciphertext[0] != KEYRING_VERSION_1 {
+		return nil, nil, ErrUnknownKeyringVersion
+	}
+	size := 2 + int(ciphertext[1]) + 4
+	if ciphertext[1] == 0 || len(ciphertext) < size {
+		return nil, nil, ErrInvalidKeyringCiphertext
+	}
+	return ciphertext[:size], ciphertext[size:], nil
+}
+
+// MemoryKeyStore keeps the keys in memory only, for tests and short lived keys
+type MemoryKeyStore struct {
+	mu   sync.Mutex
+	keys []KeyVersion
+}
+
+func NewMemoryKeyStore() *MemoryKeyStore {
+	return &MemoryKeyStore{}
+}
+
+func (s *MemoryKeyStore) Load() ([]KeyVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]KeyVersion(nil), s.keys...), nil
+}
+
+func (s *MemoryKeyStore) Save(keys []KeyVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append([]KeyVersion(nil), keys...)
+	return nil
+}
+
+// FileKeyStore keeps the keys in a local json file (mode 0600), no network or
+// KMS needed. With a master key every key is sealed with AES-256-GCM, without
+// it the keys are stored in base64 and the file itself must be protected.
+type FileKeyStore struct {
+	mu        sync.Mutex
+	path      string
+	masterKey []byte
+}
+
+type keyFile struct {
+	Sealed bool          `json:"sealed"`
+	Keys   []keyFileItem `json:"keys"`
+}
+
+type keyFileItem struct {
+	Name    string    `json:"name"`
+	Version uint32    `json:"version"`
+	Key     string    `json:"key"`
+	Created time.Time `json:"created"`
+}
+
+// NewFileKeyStore uses the file at path, it is created on the first Save.
+// masterKey (32 bytes) may be nil.
+func NewFileKeyStore(path string, masterKey []byte) *FileKeyStore {
+	return &FileKeyStore{path: path, masterKey: masterKey}
+}
+
+func (s *FileKeyStore) Load() ([]KeyVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	raw, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file keyFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("encryption: key file %s: %w", s.path, err)
+	}
+	if file.Sealed && s.masterKey == nil {
+		return nil, ErrKeyringStoreNeedsMasterKey
+	}
+	keys := make([]KeyVersion, 0, len(file.Keys))
+	for _, item := range file.Keys {
+		kv := KeyVersion{Name: item.Name, Version: item.Version, Created: item.Created}
+		key, err := base64.StdEncoding.DecodeString(item.Key)
+		if err != nil {
+			return nil, fmt.Errorf("encryption: key %s: %w", kv.ID(), err)
+		}
+		if file.Sealed {
+			if key, err = Open(s.masterKey, key, []byte(kv.ID())); err != nil {
+				return nil, fmt.Errorf("encryption: key %s: %w", kv.ID(), err)
+			}
+		}
+		kv.Key = key
+		keys = append(keys, kv)
+	}
+	return keys, nil
+}
+
+// Save writes to a temporary file first and renames it, a crash never leaves
+// a half written key file
+func (s *FileKeyStore) Save(keys []KeyVersion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file := keyFile{Sealed: s.masterKey != nil, Keys: make([]keyFileItem, 0, len(keys))}
+	for _, kv := range keys {
+		key := kv.Key
+		if file.Sealed {
+			sealed, err := Seal(AES256GCM, s.masterKey, kv.Key, []byte(kv.ID()))
+			if err != nil {
+				return err
+			}
+			key = sealed
+		}
+		file.Keys = append(file.Keys, keyFileItem{Name: kv.Name, Version: kv.Version, Key: base64.StdEncoding.EncodeToString(key), Created: kv.Created})
+	}
+	sort.Slice(file.Keys, func(i, j int) bool {
+		if file.Keys[i].Name != file.Keys[j].Name {
+			return file.Keys[i].Name < file.Keys[j].Name
+		}
+		return file.Keys[i].Version < file.Keys[j].Version
+	})
+	raw, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(KEYRING_FILE_MODE); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,146 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	kr, err := NewKeyring(NewMemoryKeyStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kr.AddKey("users"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := kr.AddKey("users"); !errors.Is(err, ErrKeyExists) {
+		t.Fatalf("second AddKey err = %v", err)
+	}
+
+	old, err := kr.Encrypt("users", []byte("secret"), []byte("user:42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2, err := kr.Rotate("users"); err != nil || v2.ID() != "users/v2" {
+		t.Fatalf("Rotate = %v, %v", v2, err)
+	}
+
+	plain, err := kr.Decrypt(old, []byte("user:42"))
+	if err != nil || string(plain) != "secret" {
+		t.Fatalf("old ciphertext after rotate = %q, %v", plain, err)
+	}
+	if _, err := kr.Decrypt(old, []byte("user:43")); !errors.Is(err, ErrAEADOpen) {
+		t.Fatalf("wrong aad err = %v", err)
+	}
+	if needs, _ := kr.NeedsRewrap(old); !needs {
+		t.Fatal("v1 ciphertext does not need rewrap")
+	}
+
+	rewrapped, err := kr.Rewrap(old, []byte("user:42"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, version, err := CiphertextKeyID(rewrapped); name != "users" || version != 2 || err != nil {
+		t.Fatalf("rewrapped key id = %s/v%d, %v", name, version, err)
+	}
+	if plain, err := kr.Decrypt(rewrapped, []byte("user:42")); err != nil || string(plain) != "secret" {
+		t.Fatalf("rewrapped = %q, %v", plain, err)
+	}
+}
+
+func TestKeyringTamper(t *testing.T) {
+	kr, _ := NewKeyring(nil)
+	kr.AddKey("a")
+	kr.AddKey("b")
+	blob, _ := kr.Encrypt("a", []byte("secret"), nil)
+
+	// pointing the header at another key of the same name length must fail
+	other := bytes.Clone(blob)
+	other[2] = 'b'
+	if _, err := kr.Decrypt(other, nil); !errors.Is(err, ErrAEADOpen) {
+		t.Errorf("swapped key name err = %v", err)
+	}
+	tampered := bytes.Clone(blob)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := kr.Decrypt(tampered, nil); !errors.Is(err, ErrAEADOpen) {
+		t.Errorf("tampered data err = %v", err)
+	}
+	if _, err := kr.Decrypt([]byte{KEYRING_VERSION_1, 1, 'z', 0, 0, 0, 1}, nil); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("unknown key err = %v", err)
+	}
+	if _, err := kr.Decrypt([]byte{9, 0}, nil); !errors.Is(err, ErrUnknownKeyringVersion) {
+		t.Errorf("unknown version err = %v", err)
+	}
+}
+
+func TestKeyringDeriveKey(t *testing.T) {
+	kr, _ := NewKeyring(nil)
+	kr.AddKey("users")
+	mac1, version, _ := kr.DeriveKey("users", "session-mac")
+	mac2, _, _ := kr.DeriveKey("users", "session-mac")
+	col, _, _ := kr.DeriveKey("users", "db-column:email")
+	if !bytes.Equal(mac1, mac2) || bytes.Equal(mac1, col) || len(mac1) != AEAD_KEY_SIZE || version != 1 {
+		t.Fatal("derived keys are not stable per purpose")
+	}
+	if _, _, err := kr.DeriveKey("users", KEYRING_KEK_INFO); err == nil {
+		t.Fatal("DeriveKey gave out the KEK")
+	}
+	if _, err := kr.DeriveKeyVersion("users", 1, KEYRING_KEK_INFO); err == nil {
+		t.Fatal("DeriveKeyVersion gave out the KEK")
+	}
+
+	// after Rotate the current subkey changes, the old one is still derivable
+	kr.Rotate("users")
+	rotated, version, _ := kr.DeriveKey("users", "session-mac")
+	if version != 2 || bytes.Equal(rotated, mac1) {
+		t.Fatalf("version = %d, subkey must change with the key version", version)
+	}
+	old, err := kr.DeriveKeyVersion("users", 1, "session-mac")
+	if err != nil || !bytes.Equal(old, mac1) {
+		t.Fatalf("v1 subkey after rotate = %x, %v", old, err)
+	}
+	if _, err := kr.DeriveKeyVersion("users", 3, "session-mac"); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("unknown version err = %v", err)
+	}
+}
+
+func TestFileKeyStore(t *testing.T) {
+	for _, master := range [][]byte{nil, []byte(aeadTestKey)} {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		kr, err := NewKeyring(NewFileKeyStore(path, master))
+		if err != nil {
+			t.Fatal(err)
+		}
+		kr.AddKey("users")
+		kr.Rotate("users")
+		blob, _ := kr.Encrypt("users", []byte("secret"), nil)
+
+		info, err := os.Stat(path)
+		if err != nil || info.Mode().Perm() != KEYRING_FILE_MODE {
+			t.Fatalf("key file mode = %v, %v", info, err)
+		}
+
+		reloaded, err := NewKeyring(NewFileKeyStore(path, master))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if current, _ := reloaded.Current("users"); current.Version != 2 {
+			t.Fatalf("reloaded current = %s", current.ID())
+		}
+		if plain, err := reloaded.Decrypt(blob, nil); err != nil || string(plain) != "secret" {
+			t.Fatalf("reloaded decrypt = %q, %v", plain, err)
+		}
+
+		if master != nil {
+			if _, err := NewKeyring(NewFileKeyStore(path, nil)); !errors.Is(err, ErrKeyringStoreNeedsMasterKey) {
+				t.Fatalf("sealed store without master key err = %v", err)
+			}
+			if _, err := NewKeyring(NewFileKeyStore(path, bytes.Repeat([]byte("x"), AEAD_KEY_SIZE))); !errors.Is(err, ErrAEADOpen) {
+				t.Fatalf("sealed store with wrong master key err = %v", err)
+			}
+		}
+	}
+}
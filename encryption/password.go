@@ -0,0 +1,327 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Password hashing with a random salt per password, the result is a PHC
+// string that carries the algorithm and parameters so they can change later
+// without breaking the stored hashes.
+//
+// Usage:
+//
+//	hash, err := encryption.HashPassword("s3cret") // argon2id with DefaultPasswordParams
+//	// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+//
+//	ok, err := encryption.VerifyPassword("s3cret", hash)
+//	if ok && encryption.NeedsRehash(hash, encryption.DefaultPasswordParams) {
+//		hash, _ = encryption.HashPassword("s3cret") // save the upgraded hash
+//	}
+//
+//	// other algorithms
+//	params := encryption.DefaultPasswordParams
+//	params.Algorithm = encryption.PASSWORD_SCRYPT // $scrypt$ln=15,r=8,p=1$<salt>$<hash>
+//	hash, err = encryption.HashPasswordWith("s3cret", params)
+//
+// Salt and hash are unpadded standard base64. bcrypt keeps its own modular
+// crypt format ($2a$10$...), which VerifyPassword and NeedsRehash understand
+// as well. bcrypt only uses the first 72 bytes, longer passwords are rejected.
+
+type PasswordAlgorithm string
+
+const (
+	PASSWORD_ARGON2ID PasswordAlgorithm = "argon2id"
+	PASSWORD_BCRYPT   PasswordAlgorithm = "bcrypt"
+	PASSWORD_SCRYPT   PasswordAlgorithm = "scrypt"
+
+	DEFAULT_ARGON2_MEMORY  = 64 * 1024 // in KiB, 64MB
+	DEFAULT_ARGON2_TIME    = 3
+	DEFAULT_ARGON2_THREADS = 4
+	DEFAULT_BCRYPT_COST    = 12
+	DEFAULT_SCRYPT_N       = 1 << 15
+	DEFAULT_SCRYPT_R       = 8
+	DEFAULT_SCRYPT_P       = 1
+	DEFAULT_SALT_LENGTH    = 16
+	DEFAULT_KEY_LENGTH     = 32
+
+	// upper bounds for parameters read from a stored hash, so a crafted hash
+	// cannot make VerifyPassword allocate or spin without limit
+	MAX_ARGON2_MEMORY  = 1024 * 1024 // in KiB, 1GB
+	MAX_ARGON2_TIME    = 64
+	MAX_SCRYPT_LOG_N   = 24
+	MAX_SCRYPT_MEMORY  = 1 << 30 // in bytes, scrypt needs 128*N*r
+	MAX_PASSWORD_BYTES = 1024    // decoded salt and hash, each
+)
+
+var (
+	ErrInvalidPasswordHash          = errors.New("encryption: invalid password hash")
+	ErrUnsupportedPasswordAlgorithm = errors.New("encryption: unsupported password hash algorithm")
+)
+
+type PasswordParams struct {
+	Algorithm PasswordAlgorithm
+
+	// argon2id
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+
+	// bcrypt
+	Cost int
+
+	// scrypt, N must be a power of 2
+	N int
+	R int
+	P int
+
+	SaltLength int // argon2id and scrypt
+	KeyLength  int // argon2id and scrypt
+}
+
+// DefaultPasswordParams is used by HashPassword, change it once at startup to
+// move every new hash to other parameters (and use NeedsRehash for old ones)
+var DefaultPasswordParams = PasswordParams{
+	Algorithm:  PASSWORD_ARGON2ID,
+	Memory:     DEFAULT_ARGON2_MEMORY,
+	Time:       DEFAULT_ARGON2_TIME,
+	Threads:    DEFAULT_ARGON2_THREADS,
+	Cost:       DEFAULT_BCRYPT_COST,
+	N:          DEFAULT_SCRYPT_N,
+	R:          DEFAULT_SCRYPT_R,
+	P:          DEFAULT_SCRYPT_P,
+	SaltLength: DEFAULT_SALT_LENGTH,
+	KeyLength:  DEFAULT_KEY_LENGTH,
+}
+
+// HashPassword hashes with DefaultPasswordParams
+func HashPassword(password string) (string, error) {
+	return HashPasswordWith(password, DefaultPasswordParams)
+}
+
+// HashPasswordWith hashes with the given algorithm and parameters, zero values
+// are taken from the DEFAULT_ constants
+func HashPasswordWith(password string, params PasswordParams) (string, error) {
+	params = params.withDefaults()
+	if params.Algorithm == PASSWORD_BCRYPT {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), params.Cost)
+		return string(hash), err
+	}
+
+	salt := make([]byte, params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	switch params.Algorithm {
+	case PASSWORD_ARGON2ID:
+		key := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(params.KeyLength))
+		return encodePHC(params, salt, key), nil
+	case PASSWORD_SCRYPT:
+		if params.N < 2 || params.N&(params.N-1) != 0 {
+			return "", fmt.Errorf("encryption: scrypt N must be a power of 2, got %d", params.N)
+		}
+		key, err := scrypt.Key([]byte(password), salt, params.N, params.R, params.P, params.KeyLength)
+		if err != nil {
+			return "", err
+		}
+		return encodePHC(params, salt, key), nil
+	}
+	return "", ErrUnsupportedPasswordAlgorithm
+}
+
+// VerifyPassword checks password against a hash from HashPassword. A wrong
+// password is (false, nil), err is only set for a malformed or unsupported hash.
+func VerifyPassword(password, encoded string) (bool, error) {
+	params, salt, key, err := decodePasswordHash(encoded)
+	if err != nil {
+		return false, err
+	}
+	var computed []byte
+	switch params.Algorithm {
+	case PASSWORD_BCRYPT:
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	case PASSWORD_ARGON2ID:
+		computed = argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, uint32(len(key)))
+	case PASSWORD_SCRYPT:
+		computed, err = scrypt.Key([]byte(password), salt, params.N, params.R, params.P, len(key))
+		if err != nil {
+			return false, err
+		}
+	}
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+// NeedsRehash is true when the hash was made with another algorithm or other
+// parameters than params, rehash the password after a successful
+// VerifyPassword. A malformed hash also needs a rehash.
+func NeedsRehash(encoded string, params PasswordParams) bool {
+	current, salt, key, err := decodePasswordHash(encoded)
+	if err != nil {
+		return true
+	}
+	params = params.withDefaults()
+	if current.Algorithm != params.Algorithm {
+		return true
+	}
+	switch params.Algorithm {
+	case PASSWORD_BCRYPT:
+		return current.Cost != params.Cost
+	case PASSWORD_ARGON2ID:
+		if current.Memory != params.Memory || current.Time != params.Time || current.Threads != params.Threads {
+			return true
+		}
+	case PASSWORD_SCRYPT:
+		if current.N != params.N || current.R != params.R || current.P != params.P {
+			return true
+		}
+	}
+	return len(salt) != params.SaltLength || len(key) != params.KeyLength
+}
+
+func (p PasswordParams) withDefaults() PasswordParams {
+	if p.Algorithm == "" {
+		p.Algorithm = PASSWORD_ARGON2ID
+	}
+	if p.Memory == 0 {
+		p.Memory = DEFAULT_ARGON2_MEMORY
+	}
+	if p.Time == 0 {
+		p.Time = DEFAULT_ARGON2_TIME
+	}
+	if p.Threads == 0 {
+		p.Threads = DEFAULT_ARGON2_THREADS
+	}
+	if p.Cost == 0 {
+		p.Cost = DEFAULT_BCRYPT_COST
+	}
+	if p.N == 0 {
+		p.N = DEFAULT_SCRYPT_N
+	}
+	if p.R == 0 {
+		p.R = DEFAULT_SCRYPT_R
+	}
+	if p.P == 0 {
+		p.P = DEFAULT_SCRYPT_P
+	}
+	if p.SaltLength == 0 {
+		p.SaltLength = DEFAULT_SALT_LENGTH
+	}
+	if p.KeyLength == 0 {
+		p.KeyLength = DEFAULT_KEY_LENGTH
+	}
+	return p
+}
+
+func encodePHC(params PasswordParams, salt, key []byte) string {
+	var options string
+	switch params.Algorithm {
+	case PASSWORD_ARGON2ID:
+		options = fmt.Sprintf("v=%d$m=%d,t=%d,p=%d", argon2.Version, params.Memory, params.Time, params.Threads)
+	case PASSWORD_SCRYPT:
+		options = fmt.Sprintf("ln=%d,r=%d,p=%d", bits.Len(uint(params.N))-1, params.R, params.P)
+	}
+	return "$" + string(params.Algorithm) + "$" + options + "$" +
+		base64.RawStdEncoding.EncodeToString(salt) + "$" + base64.RawStdEncoding.EncodeToString(key)
+}
+
+// decodePasswordHash parses a PHC string (or a bcrypt hash, salt and key are
+// then nil)
+func decodePasswordHash(encoded string) (params PasswordParams, salt, key []byte, err error) {
+	if strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$") {
+		cost, err := bcrypt.Cost([]byte(encoded))
+		if err != nil {
+			return params, nil, nil, ErrInvalidPasswordHash
+		}
+		return PasswordParams{Algorithm: PASSWORD_BCRYPT, Cost: cost}, nil, nil, nil
+	}
+
+	// "", algorithm, [version,] options, salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) < 5 || parts[0] != "" {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	params.Algorithm = PasswordAlgorithm(parts[1])
+	var options map[string]int
+	switch params.Algorithm {
+	case PASSWORD_ARGON2ID:
+		if len(parts) != 6 || parts[2] != "v="+strconv.Itoa(argon2.Version) {
+			return params, nil, nil, ErrInvalidPasswordHash
+		}
+		options, err = parsePHCOptions(parts[3], "m", "t", "p")
+		if err != nil {
+			return params, nil, nil, err
+		}
+		if options["m"] > MAX_ARGON2_MEMORY || options["t"] > MAX_ARGON2_TIME || options["p"] > 255 {
+			return params, nil, nil, ErrInvalidPasswordHash
+		}
+		params.Memory, params.Time, params.Threads = uint32(options["m"]), uint32(options["t"]), uint8(options["p"])
+	case PASSWORD_SCRYPT:
+		if len(parts) != 5 {
+			return params, nil, nil, ErrInvalidPasswordHash
+		}
+		options, err = parsePHCOptions(parts[2], "ln", "r", "p")
+		if err != nil {
+			return params, nil, nil, err
+		}
+		if options["ln"] > MAX_SCRYPT_LOG_N || options["r"] > 64 || options["p"] > 64 ||
+			128*(1<<options["ln"])*options["r"] > MAX_SCRYPT_MEMORY {
+			return params, nil, nil, ErrInvalidPasswordHash
+		}
+		params.N, params.R, params.P = 1<<options["ln"], options["r"], options["p"]
+	default:
+		return params, nil, nil, ErrUnsupportedPasswordAlgorithm
+	}
+
+	maxEncoded := base64.RawStdEncoding.EncodedLen(MAX_PASSWORD_BYTES)
+	if len(parts[len(parts)-2]) > maxEncoded || len(parts[len(parts)-1]) > maxEncoded {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[len(parts)-2])
+	if err != nil || len(salt) == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[len(parts)-1])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidPasswordHash
+	}
+	params.SaltLength, params.KeyLength = len(salt), len(key)
+	return params, salt, key, nil
+}
+
+// parsePHCOptions parses "m=65536,t=3,p=4", every name must be present once
+// with a positive value and no other name is accepted
+func parsePHCOptions(s string, names ...string) (map[string]int, error) {
+	options := make(map[string]int, len(names))
+	for _, pair := range strings.Split(s, ",") {
+		name, value, ok := strings.Cut(pair, "=")
+		n, err := strconv.Atoi(value)
+		if !ok || err != nil || n <= 0 || !slices.Contains(names, name) {
+			return nil, ErrInvalidPasswordHash
+		}
+		if _, seen := options[name]; seen {
+			return nil, ErrInvalidPasswordHash
+		}
+		options[name] = n
+	}
+	for _, name := range names {
+		if _, ok := options[name]; !ok {
+			return nil, ErrInvalidPasswordHash
+		}
+	}
+	return options, nil
+}
@@ -0,0 +1,104 @@
+package encryption
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// small parameters so the tests stay fast
+var testPasswordParams = PasswordParams{Memory: 1024, Time: 1, Threads: 1, Cost: 4, N: 1 << 10}
+
+func TestHashVerifyPassword(t *testing.T) {
+	for _, alg := range []PasswordAlgorithm{PASSWORD_ARGON2ID, PASSWORD_BCRYPT, PASSWORD_SCRYPT} {
+		params := testPasswordParams
+		params.Algorithm = alg
+		hash, err := HashPasswordWith("s3cret", params)
+		if err != nil {
+			t.Fatalf("%s: %v", alg, err)
+		}
+		other, _ := HashPasswordWith("s3cret", params)
+		if hash == other {
+			t.Errorf("%s: same hash twice, salt is not random", alg)
+		}
+		if ok, err := VerifyPassword("s3cret", hash); !ok || err != nil {
+			t.Errorf("%s: verify %s = %v, %v", alg, hash, ok, err)
+		}
+		if ok, err := VerifyPassword("wrong", hash); ok || err != nil {
+			t.Errorf("%s: verify wrong password = %v, %v", alg, ok, err)
+		}
+	}
+}
+
+func TestPasswordHashFormat(t *testing.T) {
+	params := testPasswordParams
+	hash, _ := HashPasswordWith("pw", params)
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("argon2id hash = %s", hash)
+	}
+	params.Algorithm = PASSWORD_SCRYPT
+	hash, _ = HashPasswordWith("pw", params)
+	if !strings.HasPrefix(hash, "$scrypt$ln=10,r=8,p=1$") {
+		t.Errorf("scrypt hash = %s", hash)
+	}
+	params.Algorithm = PASSWORD_BCRYPT
+	hash, _ = HashPasswordWith("pw", params)
+	if !strings.HasPrefix(hash, "$2a$04$") {
+		t.Errorf("bcrypt hash = %s", hash)
+	}
+}
+
+func TestVerifyPasswordInvalid(t *testing.T) {
+	cases := map[string]error{
+		"plain":                                 ErrInvalidPasswordHash,
+		"$md5$abc$def$ghi":                      ErrUnsupportedPasswordAlgorithm,
+		"$argon2id$v=19$m=1024,t=1$c2FsdA$a2V5": ErrInvalidPasswordHash,
+		"$argon2id$v=18$m=1024,t=1,p=1$c2FsdA$a2V5":      ErrInvalidPasswordHash,
+		"$argon2id$v=19$m=999999999,t=1,p=1$c2FsdA$a2V5": ErrInvalidPasswordHash,
+		"$scrypt$ln=40,r=8,p=1$c2FsdA$a2V5":              ErrInvalidPasswordHash,
+		// 128 * 2^24 * 64 = 128GB and 4GB of memory
+		"$scrypt$ln=24,r=64,p=1$c2FsdA$a2V5":           ErrInvalidPasswordHash,
+		"$argon2id$v=19$m=4194304,t=1,p=1$c2FsdA$a2V5": ErrInvalidPasswordHash,
+		"$argon2id$v=19$m=1048577,t=1,p=1$c2FsdA$a2V5": ErrInvalidPasswordHash,
+		"$scrypt$ln=21,r=8,p=1$c2FsdA$a2V5":            ErrInvalidPasswordHash,
+		"$scrypt$ln=10,r=8,p=1$!!$a2V5":                ErrInvalidPasswordHash,
+		// unknown or repeated parameters
+		"$argon2id$v=19$m=1024,t=1,p=1,x=5$c2FsdA$a2V5": ErrInvalidPasswordHash,
+		"$scrypt$ln=10,r=8,p=1,ln=11$c2FsdA$a2V5":       ErrInvalidPasswordHash,
+		// hash longer than MAX_PASSWORD_BYTES
+		"$scrypt$ln=10,r=8,p=1$c2FsdA$" + strings.Repeat("A", 2000): ErrInvalidPasswordHash,
+	}
+	for hash, want := range cases {
+		if ok, err := VerifyPassword("pw", hash); ok || !errors.Is(err, want) {
+			t.Errorf("%s: ok = %v, err = %v, want %v", hash, ok, err, want)
+		}
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	hash, _ := HashPasswordWith("pw", testPasswordParams)
+	if NeedsRehash(hash, testPasswordParams) {
+		t.Error("same params need rehash")
+	}
+	stronger := testPasswordParams
+	stronger.Time = 2
+	if !NeedsRehash(hash, stronger) {
+		t.Error("higher time does not need rehash")
+	}
+	stronger = testPasswordParams
+	stronger.Algorithm = PASSWORD_BCRYPT
+	if !NeedsRehash(hash, stronger) {
+		t.Error("other algorithm does not need rehash")
+	}
+	bcryptHash, _ := HashPasswordWith("pw", stronger)
+	if NeedsRehash(bcryptHash, stronger) {
+		t.Error("bcrypt same cost needs rehash")
+	}
+	stronger.Cost = 5
+	if !NeedsRehash(bcryptHash, stronger) {
+		t.Error("bcrypt higher cost does not need rehash")
+	}
+	if !NeedsRehash("garbage", testPasswordParams) {
+		t.Error("garbage does not need rehash")
+	}
+}
@@ -3,10 +3,15 @@ module github.com/medatechnology/goutil
 go 1.23.2
 
 require (
+	github.com/andybalholm/brotli v1.2.0
 	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.18.0
 	github.com/lithammer/shortuuid/v4 v4.2.0
 	golang.org/x/crypto v0.37.0
 )
 
-require github.com/google/uuid v1.6.0 // indirect
+require (
+	github.com/google/uuid v1.6.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
+)
@@ -0,0 +1,369 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/medatechnology/goutil/medattlmap"
+)
+
+// Pluggable authentication. The authenticator is applied to every attempt of
+// every request, right before it goes through the middleware chain.
+// Usage:
+//
+//	c.SetAuthenticator(httpclient.BearerToken("static-token"))
+//
+//	oauth := httpclient.NewOAuth2ClientCredentials(tokenURL, clientID, secret, "read", "write")
+//	c.SetAuthenticator(oauth)
+//
+//	c.SetAuthenticator(&httpclient.HMACSigner{KeyID: "svc-a", Secret: []byte(secret)})
+//
+// When the server replies 401 and the authenticator implements TokenInvalidator
+// (OAuth2 does) the cached token is dropped and the request is sent once more
+// with a fresh token.
+
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// TokenInvalidator is implemented by authenticators that cache credentials
+type TokenInvalidator interface {
+	Invalidate()
+}
+
+// SetAuthenticator sets how requests are authenticated, nil removes it. Basic
+// auth from SetBasicAuth is applied before and can be overwritten by it.
+func (h *httpClient) SetAuthenticator(auth Authenticator) *httpClient {
+	h.mu.Lock()
+	h.auth = auth
+	h.mu.Unlock()
+	return h
+}
+
+// authenticate applies the authenticator to one attempt
+func (h *httpClient) authenticate(req *http.Request) error {
+	auth := h.authenticator()
+	if auth == nil {
+		return nil
+	}
+	return auth.Authenticate(req)
+}
+
+func (h *httpClient) authenticator() Authenticator {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.auth
+}
+
+// ===== Static bearer token
+
+type bearerToken string
+
+// BearerToken sets "Authorization: Bearer <token>" on every request
+func BearerToken(token string) Authenticator {
+	return bearerToken(token)
+}
+
+func (b bearerToken) Authenticate(req *http.Request) error {
+	req.Header.Set("Authorization", "Bearer "+string(b))
+	return nil
+}
+
+// ===== OAuth2 client credentials (RFC 6749 section 4.4)
+
+const (
+	DEFAULT_OAUTH2_TOKEN_TTL      = 5 * time.Minute  // when the token endpoint does not send expires_in
+	DEFAULT_OAUTH2_REFRESH_BEFORE = 30 * time.Second // refresh this long before the token expires
+)
+
+var (
+	oauth2TokenCache     *medattlmap.TTLMap
+	oauth2TokenCacheOnce sync.Once
+)
+
+// tokens are shared by every authenticator with the same url, client, secret,
+// scopes and endpoint params, so several clients do not each fetch their own token
+func oauth2Cache() *medattlmap.TTLMap {
+	oauth2TokenCacheOnce.Do(func() {
+		oauth2TokenCache = medattlmap.NewTTLMap(DEFAULT_OAUTH2_TOKEN_TTL, 0)
+	})
+	return oauth2TokenCache
+}
+
+type OAuth2ClientCredentials struct {
+	TokenURL          string
+	ClientID          string
+	ClientSecret      string
+	Scopes            []string
+	EndpointParams    url.Values    // extra form values for the token request (ie: audience)
+	CredentialsInBody bool          // send client_id/client_secret in the form instead of basic auth
+	RefreshBefore     time.Duration // 0 uses DEFAULT_OAUTH2_REFRESH_BEFORE
+	Client            *http.Client  // for the token request, nil uses http.DefaultClient
+	mu                sync.Mutex
+}
+
+func NewOAuth2ClientCredentials(tokenURL, clientID, clientSecret string, scopes ...string) *OAuth2ClientCredentials {
+	return &OAuth2ClientCredentials{
+		TokenURL:     tokenURL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Scopes:       scopes,
+	}
+}
+
+type oauth2TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (o *OAuth2ClientCredentials) Authenticate(req *http.Request) error {
+	token, err := o.Token(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Invalidate drops the cached token, the next request fetches a new one
+func (o *OAuth2ClientCredentials) Invalidate() {
+	oauth2Cache().Delete(o.cacheKey())
+}
+
+// Token returns the cached access token or fetches a new one
+func (o *OAuth2ClientCredentials) Token(ctx context.Context) (string, error) {
+	key := o.cacheKey()
+	if token, ok := oauth2Cache().Get(key); ok {
+		return token.(string), nil
+	}
+	// only one fetch at a time, the others wait and use its token
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if token, ok := oauth2Cache().Get(key); ok {
+		return token.(string), nil
+	}
+	tr, err := o.fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	oauth2Cache().Put(key, o.cacheTTL(tr.ExpiresIn), tr.AccessToken)
+	return tr.AccessToken, nil
+}
+
+// cacheKey covers everything that changes the issued token, the secret is
+// hashed so it is not kept in the map key in clear text
+func (o *OAuth2ClientCredentials) cacheKey() string {
+	sum := sha256.Sum256([]byte(o.ClientSecret + "\x00" + o.EndpointParams.Encode()))
+	return o.TokenURL + "|" + o.ClientID + "|" + strings.Join(o.Scopes, " ") + "|" + hex.EncodeToString(sum[:])
+}
+
+// cacheTTL keeps the token until RefreshBefore its expiry, but at least half
+// of its lifetime and never under a second (TTLMap treats 0 as its default)
+func (o *OAuth2ClientCredentials) cacheTTL(expiresIn int64) time.Duration {
+	lifetime := DEFAULT_OAUTH2_TOKEN_TTL
+	if expiresIn > 0 {
+		lifetime = time.Duration(expiresIn) * time.Second
+	}
+	refreshBefore := o.RefreshBefore
+	if refreshBefore <= 0 {
+		refreshBefore = DEFAULT_OAUTH2_REFRESH_BEFORE
+	}
+	ttl := lifetime - refreshBefore
+	if ttl < lifetime/2 {
+		ttl = lifetime / 2
+	}
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttl
+}
+
+func (o *OAuth2ClientCredentials) fetch(ctx context.Context) (*oauth2TokenResponse, error) {
+	form := url.Values{}
+	for k, v := range o.EndpointParams {
+		form[k] = append([]string(nil), v...)
+	}
+	form.Set("grant_type", "client_credentials")
+	if len(o.Scopes) > 0 {
+		form.Set("scope", strings.Join(o.Scopes, " "))
+	}
+	if o.CredentialsInBody {
+		form.Set("client_id", o.ClientID)
+		form.Set("client_secret", o.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", MIME_FORM)
+	req.Header.Set("Accept", MIME_JSON)
+	if !o.CredentialsInBody {
+		req.SetBasicAuth(url.QueryEscape(o.ClientID), url.QueryEscape(o.ClientSecret))
+	}
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer drainBody(resp)
+	if resp.StatusCode >= 300 {
+		return nil, streamStatusError(resp)
+	}
+	var tr oauth2TokenResponse
+	body := newLimitedBody(resp.Body, DEFAULT_MAX_ERROR_BODY_SIZE, newBodyTooLargeError(resp, DEFAULT_MAX_ERROR_BODY_SIZE))
+	if err := json.NewDecoder(body).Decode(&tr); err != nil {
+		return nil, err
+	}
+	if tr.AccessToken == "" {
+		return nil, errors.New("oauth2: token endpoint returned no access_token")
+	}
+	return &tr, nil
+}
+
+// ===== HMAC request signing
+
+const (
+	HMAC_KEY_ID_HEADER    = "X-Key-Id"
+	HMAC_TIMESTAMP_HEADER = "X-Timestamp"
+	HMAC_SIGNATURE_HEADER = "X-Signature"
+
+	DEFAULT_HMAC_MAX_BODY_SIZE = 10 << 20 // 10MB, bigger bodies are rejected by VerifyHMAC
+)
+
+var (
+	ErrHMACInvalidSignature = errors.New("hmac: invalid signature")
+	ErrHMACBodyTooLarge     = errors.New("hmac: request body too large")
+)
+
+// HMACSigner signs method, path with query, unix timestamp and sha256 of the
+// body. The string to sign is (see HMACStringToSign):
+//
+//	METHOD\n/path?query\nTIMESTAMP\nHEX(SHA256(body))
+//
+// and the hex encoded HMAC goes into X-Signature, the timestamp into X-Timestamp
+// and KeyID (if set) into X-Key-Id. Streamed bodies without GetBody (multipart
+// with io.Reader files) cannot be signed.
+type HMACSigner struct {
+	KeyID  string
+	Secret []byte
+	Hash   func() hash.Hash // nil uses sha256.New
+	// MaxBodySize limits the body VerifyHMAC reads, 0 uses
+	// DEFAULT_HMAC_MAX_BODY_SIZE and a negative size means no limit
+	MaxBodySize int64
+	now         func() time.Time
+}
+
+func (s *HMACSigner) Authenticate(req *http.Request) error {
+	bodyHash, err := requestBodyHash(req)
+	if err != nil {
+		return err
+	}
+	now := time.Now
+	if s.now != nil {
+		now = s.now
+	}
+	timestamp := strconv.FormatInt(now().Unix(), 10)
+	req.Header.Set(HMAC_TIMESTAMP_HEADER, timestamp)
+	if s.KeyID != "" {
+		req.Header.Set(HMAC_KEY_ID_HEADER, s.KeyID)
+	}
+	req.Header.Set(HMAC_SIGNATURE_HEADER, s.sign(HMACStringToSign(req.Method, req.URL.RequestURI(), timestamp, bodyHash)))
+	return nil
+}
+
+func (s *HMACSigner) sign(stringToSign string) string {
+	hashFunc := s.Hash
+	if hashFunc == nil {
+		hashFunc = sha256.New
+	}
+	mac := hmac.New(hashFunc, s.Secret)
+	mac.Write([]byte(stringToSign))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HMACStringToSign builds the string that HMACSigner signs
+func HMACStringToSign(method, requestURI, timestamp, bodyHash string) string {
+	return strings.Join([]string{method, requestURI, timestamp, bodyHash}, "\n")
+}
+
+// VerifyHMAC checks a request signed by HMACSigner on the server side. The
+// body is read and replaced so the handler can still read it. maxSkew 0 skips
+// the timestamp check. A body over MaxBodySize fails with ErrHMACBodyTooLarge
+// before anything is hashed.
+func (s *HMACSigner) VerifyHMAC(r *http.Request, maxSkew time.Duration) error {
+	timestamp := r.Header.Get(HMAC_TIMESTAMP_HEADER)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrHMACInvalidSignature
+	}
+	if maxSkew > 0 {
+		skew := time.Since(time.Unix(unix, 0))
+		if skew > maxSkew || skew < -maxSkew {
+			return ErrHMACInvalidSignature
+		}
+	}
+	limit := s.MaxBodySize
+	if limit == 0 {
+		limit = DEFAULT_HMAC_MAX_BODY_SIZE
+	}
+	if limit > 0 && r.ContentLength > limit {
+		return ErrHMACBodyTooLarge
+	}
+	var body []byte
+	if r.Body != nil {
+		var reader io.Reader = r.Body
+		if limit > 0 {
+			reader = io.LimitReader(r.Body, limit+1)
+		}
+		body, err = io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		if limit > 0 && int64(len(body)) > limit {
+			return ErrHMACBodyTooLarge
+		}
+		r.Body = io.NopCloser(strings.NewReader(string(body)))
+	}
+	sum := sha256.Sum256(body)
+	want := s.sign(HMACStringToSign(r.Method, r.URL.RequestURI(), timestamp, hex.EncodeToString(sum[:])))
+	if !hmac.Equal([]byte(want), []byte(r.Header.Get(HMAC_SIGNATURE_HEADER))) {
+		return ErrHMACInvalidSignature
+	}
+	return nil
+}
+
+// requestBodyHash reads the body through GetBody so the request body itself is
+// left untouched
+func requestBodyHash(req *http.Request) (string, error) {
+	h := sha256.New()
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return "", errors.New("hmac: cannot sign a streamed body without GetBody")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		defer body.Close()
+		if _, err := io.Copy(h, body); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
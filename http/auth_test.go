@@ -0,0 +1,183 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBearerToken(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer static" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetAuthenticator(BearerToken("static"))
+	if code, err := c.Get(srv.URL, nil, nil); err != nil || code != http.StatusOK {
+		t.Fatalf("code=%d err=%v", code, err)
+	}
+}
+
+func TestOAuth2ClientCredentials(t *testing.T) {
+	var issued int32
+	var current atomic.Value
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		r.ParseForm()
+		if !ok || id != "svc" || secret != "s3cret" || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != "read write" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		token := "tok-" + string(rune('0'+atomic.AddInt32(&issued, 1)))
+		current.Store(token)
+		w.Header().Set("Content-Type", MIME_JSON)
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": token, "token_type": "bearer", "expires_in": 3600})
+	}))
+	defer tokenSrv.Close()
+
+	var revoked atomic.Bool
+	apiSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if revoked.Load() && r.Header.Get("Authorization") == "Bearer tok-1" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+current.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(body)
+	}))
+	defer apiSrv.Close()
+
+	oauth := NewOAuth2ClientCredentials(tokenSrv.URL, "svc", "s3cret", "read", "write")
+	c := NewHttp()
+	c.SetHeader(map[string][]string{"Content-Type": {MIME_JSON}})
+	c.SetAuthenticator(oauth)
+
+	for i := 0; i < 3; i++ {
+		if code, err := c.Get(apiSrv.URL, nil, nil); err != nil || code != http.StatusOK {
+			t.Fatalf("code=%d err=%v", code, err)
+		}
+	}
+	if issued != 1 {
+		t.Fatalf("issued = %d, token must be cached", issued)
+	}
+
+	// server revokes the token: 401, refresh and replay the body once
+	revoked.Store(true)
+	var out map[string]string
+	code, err := c.Post(apiSrv.URL, map[string]string{"a": "b"}, &out, nil)
+	if err != nil || code != http.StatusOK || out["a"] != "b" {
+		t.Fatalf("code=%d err=%v out=%v", code, err, out)
+	}
+	if issued != 2 {
+		t.Fatalf("issued = %d, want refresh after 401", issued)
+	}
+
+	if ttl := oauth.cacheTTL(3600); ttl != time.Hour-DEFAULT_OAUTH2_REFRESH_BEFORE {
+		t.Fatalf("cacheTTL(3600) = %s", ttl)
+	}
+	if ttl := oauth.cacheTTL(1); ttl != time.Second {
+		t.Fatalf("cacheTTL(1) = %s", ttl)
+	}
+}
+
+func TestHMACSigner(t *testing.T) {
+	signer := &HMACSigner{KeyID: "svc-a", Secret: []byte("k")}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(HMAC_KEY_ID_HEADER) != "svc-a" {
+			t.Errorf("key id = %q", r.Header.Get(HMAC_KEY_ID_HEADER))
+		}
+		if err := signer.VerifyHMAC(r, time.Minute); err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetHeader(map[string][]string{"Content-Type": {MIME_JSON}})
+	c.SetQueryParams(map[string]string{"page": "2"})
+	c.SetAuthenticator(signer)
+	var out map[string]int
+	if code, err := c.Post(srv.URL+"/orders", map[string]int{"qty": 3}, &out, nil); err != nil || out["qty"] != 3 {
+		t.Fatalf("code=%d err=%v out=%v", code, err, out)
+	}
+
+	// tampered signature is rejected
+	other := &HMACSigner{KeyID: "svc-a", Secret: []byte("wrong")}
+	c.SetAuthenticator(other)
+	if code, _ := c.Get(srv.URL+"/orders", nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("code = %d, want 401 with wrong secret", code)
+	}
+}
+
+func TestVerifyHMACBodyLimit(t *testing.T) {
+	signer := &HMACSigner{Secret: []byte("s"), MaxBodySize: 16}
+	small, _ := http.NewRequest(http.MethodPost, "http://api.test/x", strings.NewReader("tiny"))
+	signer.Authenticate(small)
+	if err := signer.VerifyHMAC(small, 0); err != nil {
+		t.Fatalf("small body err = %v", err)
+	}
+
+	// no Content-Length, the reader itself is cut
+	big, _ := http.NewRequest(http.MethodPost, "http://api.test/x", io.MultiReader(strings.NewReader(strings.Repeat("x", 64))))
+	big.Header.Set(HMAC_TIMESTAMP_HEADER, strconv.FormatInt(time.Now().Unix(), 10))
+	if err := signer.VerifyHMAC(big, 0); !errors.Is(err, ErrHMACBodyTooLarge) {
+		t.Fatalf("big body err = %v", err)
+	}
+	big.ContentLength = 64
+	if err := signer.VerifyHMAC(big, 0); !errors.Is(err, ErrHMACBodyTooLarge) {
+		t.Fatalf("big Content-Length err = %v", err)
+	}
+}
+
+func TestOAuth2CacheKeySeparatesCredentials(t *testing.T) {
+	var fetched int32
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, secret, _ := r.BasicAuth()
+		r.ParseForm()
+		if secret != "right" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		atomic.AddInt32(&fetched, 1)
+		w.Header().Set("Content-Type", MIME_JSON)
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "tok-" + r.Form.Get("audience"), "expires_in": 3600})
+	}))
+	defer tokenSrv.Close()
+
+	billing := NewOAuth2ClientCredentials(tokenSrv.URL, "svc", "right")
+	billing.EndpointParams = map[string][]string{"audience": {"billing"}}
+	if token, err := billing.Token(context.Background()); err != nil || token != "tok-billing" {
+		t.Fatalf("billing token = %q, %v", token, err)
+	}
+
+	// same url and client, other audience and a wrong secret: must not get the billing token
+	admin := NewOAuth2ClientCredentials(tokenSrv.URL, "svc", "wrong")
+	admin.EndpointParams = map[string][]string{"audience": {"admin"}}
+	if token, err := admin.Token(context.Background()); err == nil {
+		t.Fatalf("admin with wrong secret got token %q", token)
+	}
+	admin.ClientSecret = "right"
+	if token, err := admin.Token(context.Background()); err != nil || token != "tok-admin" {
+		t.Fatalf("admin token = %q, %v", token, err)
+	}
+	if fetched != 2 {
+		t.Fatalf("fetched = %d, want one fetch per audience", fetched)
+	}
+}
@@ -0,0 +1,328 @@
+package httpclient
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/medatechnology/goutil/medaerror"
+)
+
+// Circuit breaker keyed by host. When the failure ratio in the rolling window
+// goes over the threshold the breaker opens and calls to that host fail fast
+// with a medaerror.MedaError (Code = CIRCUIT_OPEN_ERROR) without touching the
+// network. After CoolDown it goes half-open and lets a few probe requests
+// through, if they all succeed it closes again, any failure re-opens it.
+// Usage:
+//
+//	cb := httpclient.NewCircuitBreaker(httpclient.DefaultCircuitBreakerConfig())
+//	cb.OnStateChange(func(host string, from, to httpclient.BreakerState) {
+//		simplelog.LogFormat("breaker %s: %s -> %s", host, from, to)
+//	})
+//	c := httpclient.NewHttp()
+//	c.SetCircuitBreaker(cb)
+//
+//	_, err := c.Get(url, &result, nil)
+//	if errors.Is(err, httpclient.ErrCircuitOpen) { ... }
+
+const (
+	CIRCUIT_OPEN_ERROR = 1503 // medaerror code returned when the breaker rejects a call
+
+	DEFAULT_BREAKER_FAILURE_RATIO = 0.5
+	DEFAULT_BREAKER_MIN_REQUESTS  = 10
+	DEFAULT_BREAKER_WINDOW        = 30 * time.Second
+	DEFAULT_BREAKER_BUCKETS       = 10
+	DEFAULT_BREAKER_COOL_DOWN     = 10 * time.Second
+	DEFAULT_BREAKER_HALF_OPEN_MAX = 1
+)
+
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type CircuitBreakerConfig struct {
+	FailureRatio        float64       // open when failures/requests in the window reach this
+	MinRequests         int           // ratio is only evaluated after this many requests in the window
+	Window              time.Duration // rolling window length
+	Buckets             int           // window granularity, the oldest bucket is dropped as time moves
+	CoolDown            time.Duration // time spent open before going half-open
+	HalfOpenMaxRequests int           // probe requests allowed while half-open
+	// IsFailure decides if the call counts as failure, nil uses DefaultIsFailure
+	IsFailure func(resp *http.Response, err error) bool
+}
+
+// DefaultCircuitBreakerConfig returns the config with the DEFAULT_BREAKER_* values
+func DefaultCircuitBreakerConfig() CircuitBreakerConfig {
+	return CircuitBreakerConfig{
+		FailureRatio:        DEFAULT_BREAKER_FAILURE_RATIO,
+		MinRequests:         DEFAULT_BREAKER_MIN_REQUESTS,
+		Window:              DEFAULT_BREAKER_WINDOW,
+		Buckets:             DEFAULT_BREAKER_BUCKETS,
+		CoolDown:            DEFAULT_BREAKER_COOL_DOWN,
+		HalfOpenMaxRequests: DEFAULT_BREAKER_HALF_OPEN_MAX,
+	}
+}
+
+// DefaultIsFailure counts transport errors (timeouts included) and 5xx as
+// failure. Calls cancelled by the caller never get here, see Release.
+func DefaultIsFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.StatusCode >= 500
+}
+
+type breakerBucket struct {
+	index    int64 // which bucket-sized slice of time this bucket counts
+	requests int
+	failures int
+}
+
+type hostBreaker struct {
+	state            BreakerState
+	openedAt         time.Time
+	buckets          []breakerBucket
+	halfOpenInFlight int
+	halfOpenSuccess  int
+}
+
+type CircuitBreaker struct {
+	config        CircuitBreakerConfig
+	bucketSize    time.Duration
+	mu            sync.Mutex
+	hosts         map[string]*hostBreaker
+	onStateChange []func(host string, from, to BreakerState)
+	now           func() time.Time
+}
+
+// NewCircuitBreaker creates a breaker, zero values in config are set to default
+func NewCircuitBreaker(config CircuitBreakerConfig) *CircuitBreaker {
+	def := DefaultCircuitBreakerConfig()
+	if config.FailureRatio <= 0 || config.FailureRatio > 1 {
+		config.FailureRatio = def.FailureRatio
+	}
+	if config.MinRequests <= 0 {
+		config.MinRequests = def.MinRequests
+	}
+	if config.Window <= 0 {
+		config.Window = def.Window
+	}
+	if config.Buckets <= 0 {
+		config.Buckets = def.Buckets
+	}
+	if config.CoolDown <= 0 {
+		config.CoolDown = def.CoolDown
+	}
+	if config.HalfOpenMaxRequests <= 0 {
+		config.HalfOpenMaxRequests = def.HalfOpenMaxRequests
+	}
+	if config.IsFailure == nil {
+		config.IsFailure = DefaultIsFailure
+	}
+	bucketSize := config.Window / time.Duration(config.Buckets)
+	if bucketSize <= 0 {
+		bucketSize = 1
+	}
+	return &CircuitBreaker{
+		config:     config,
+		bucketSize: bucketSize,
+		hosts:      make(map[string]*hostBreaker),
+		now:        time.Now,
+	}
+}
+
+// OnStateChange registers a callback for every state transition, usually for
+// logging. Callbacks run synchronously after the breaker lock is released.
+func (cb *CircuitBreaker) OnStateChange(fn func(host string, from, to BreakerState)) *CircuitBreaker {
+	cb.mu.Lock()
+	cb.onStateChange = append(cb.onStateChange, fn)
+	cb.mu.Unlock()
+	return cb
+}
+
+// State returns the current state for the host (host:port as in the url)
+func (cb *CircuitBreaker) State(host string) BreakerState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if hb, ok := cb.hosts[host]; ok {
+		if hb.state == BreakerOpen && cb.now().Sub(hb.openedAt) >= cb.config.CoolDown {
+			return BreakerHalfOpen
+		}
+		return hb.state
+	}
+	return BreakerClosed
+}
+
+// Allow checks if a call to host may go through. It returns the circuit open
+// error when it may not. Every allowed call must be followed by Record, or by
+// Release when the caller cancelled it.
+func (cb *CircuitBreaker) Allow(host string) error {
+	cb.mu.Lock()
+	hb := cb.host(host)
+	from := hb.state
+	if hb.state == BreakerOpen && cb.now().Sub(hb.openedAt) >= cb.config.CoolDown {
+		cb.setState(hb, BreakerHalfOpen)
+	}
+	allowed := true
+	switch hb.state {
+	case BreakerOpen:
+		allowed = false
+	case BreakerHalfOpen:
+		if hb.halfOpenInFlight+hb.halfOpenSuccess >= cb.config.HalfOpenMaxRequests {
+			allowed = false
+		} else {
+			hb.halfOpenInFlight++
+		}
+	}
+	to := hb.state
+	cb.mu.Unlock()
+
+	cb.notify(host, from, to)
+	if !allowed {
+		return circuitOpenError(host, to)
+	}
+	return nil
+}
+
+// Record reports the outcome of an allowed call
+func (cb *CircuitBreaker) Record(host string, resp *http.Response, err error) {
+	failure := cb.config.IsFailure(resp, err)
+	cb.mu.Lock()
+	hb := cb.host(host)
+	from := hb.state
+	switch hb.state {
+	case BreakerClosed:
+		b := cb.bucket(hb)
+		b.requests++
+		if failure {
+			b.failures++
+		}
+		requests, failures := cb.totals(hb)
+		if requests >= cb.config.MinRequests && float64(failures)/float64(requests) >= cb.config.FailureRatio {
+			cb.setState(hb, BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if hb.halfOpenInFlight > 0 {
+			hb.halfOpenInFlight--
+		}
+		if failure {
+			cb.setState(hb, BreakerOpen)
+		} else {
+			hb.halfOpenSuccess++
+			if hb.halfOpenSuccess >= cb.config.HalfOpenMaxRequests {
+				cb.setState(hb, BreakerClosed)
+			}
+		}
+	}
+	to := hb.state
+	cb.mu.Unlock()
+
+	cb.notify(host, from, to)
+}
+
+// Release ends an allowed call without counting it, for calls the caller
+// cancelled (its context is done) which say nothing about the host. While
+// half-open it frees the probe slot so another probe can go through.
+func (cb *CircuitBreaker) Release(host string) {
+	cb.mu.Lock()
+	if hb, ok := cb.hosts[host]; ok && hb.state == BreakerHalfOpen && hb.halfOpenInFlight > 0 {
+		hb.halfOpenInFlight--
+	}
+	cb.mu.Unlock()
+}
+
+func (cb *CircuitBreaker) host(host string) *hostBreaker {
+	hb, ok := cb.hosts[host]
+	if !ok {
+		hb = &hostBreaker{buckets: make([]breakerBucket, cb.config.Buckets)}
+		cb.hosts[host] = hb
+	}
+	return hb
+}
+
+// setState resets the counters that belong to the new state
+func (cb *CircuitBreaker) setState(hb *hostBreaker, state BreakerState) {
+	hb.state = state
+	hb.halfOpenInFlight = 0
+	hb.halfOpenSuccess = 0
+	switch state {
+	case BreakerOpen:
+		hb.openedAt = cb.now()
+	case BreakerClosed:
+		for i := range hb.buckets {
+			hb.buckets[i] = breakerBucket{}
+		}
+	}
+}
+
+// bucket returns the bucket for the current time, clearing it if it was used
+// for an older slice of time
+func (cb *CircuitBreaker) bucket(hb *hostBreaker) *breakerBucket {
+	index := cb.now().UnixNano() / int64(cb.bucketSize)
+	b := &hb.buckets[index%int64(len(hb.buckets))]
+	if b.index != index {
+		*b = breakerBucket{index: index}
+	}
+	return b
+}
+
+// totals sums all buckets that are still inside the window
+func (cb *CircuitBreaker) totals(hb *hostBreaker) (int, int) {
+	current := cb.now().UnixNano() / int64(cb.bucketSize)
+	var requests, failures int
+	for _, b := range hb.buckets {
+		if current-b.index < int64(len(hb.buckets)) {
+			requests += b.requests
+			failures += b.failures
+		}
+	}
+	return requests, failures
+}
+
+func (cb *CircuitBreaker) notify(host string, from, to BreakerState) {
+	if from == to {
+		return
+	}
+	cb.mu.Lock()
+	callbacks := cb.onStateChange
+	cb.mu.Unlock()
+	for _, fn := range callbacks {
+		fn(host, from, to)
+	}
+}
+
+func circuitOpenError(host string, state BreakerState) error {
+	err := medaerror.NewMedaErr(CIRCUIT_OPEN_ERROR,
+		"circuit breaker "+state.String()+" for host "+host,
+		"service temporarily unavailable", host)
+	err.Err = ErrCircuitOpen
+	return err
+}
+
+// SetCircuitBreaker enables the breaker for this client. The same breaker can be
+// shared by several clients so they see the same host state.
+func (h *httpClient) SetCircuitBreaker(cb *CircuitBreaker) *httpClient {
+	h.mu.Lock()
+	h.breaker = cb
+	h.mu.Unlock()
+	return h
+}
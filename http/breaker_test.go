@@ -0,0 +1,194 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/medatechnology/goutil/medaerror"
+)
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	var healthy atomic.Bool
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	host := mustHost(t, srv.URL)
+
+	cb := NewCircuitBreaker(CircuitBreakerConfig{
+		FailureRatio: 0.5,
+		MinRequests:  4,
+		Window:       time.Minute,
+		CoolDown:     50 * time.Millisecond,
+	})
+	var transitions []string
+	cb.OnStateChange(func(h string, from, to BreakerState) {
+		if h != host {
+			t.Errorf("callback host = %s, want %s", h, host)
+		}
+		transitions = append(transitions, from.String()+">"+to.String())
+	})
+	c := NewHttp()
+	c.SetCircuitBreaker(cb)
+
+	for i := 0; i < 4; i++ {
+		c.Get(srv.URL, nil, nil)
+	}
+	if cb.State(host) != BreakerOpen {
+		t.Fatalf("state = %s, want open", cb.State(host))
+	}
+
+	_, err := c.Get(srv.URL, nil, nil)
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v, want ErrCircuitOpen", err)
+	}
+	var medaErr medaerror.MedaError
+	if !errors.As(err, &medaErr) || medaErr.Code != CIRCUIT_OPEN_ERROR {
+		t.Fatalf("err = %#v, want MedaError code %d", err, CIRCUIT_OPEN_ERROR)
+	}
+	if calls != 4 {
+		t.Fatalf("calls = %d, open breaker must not reach the server", calls)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	healthy.Store(true)
+	if code, err := c.Get(srv.URL, nil, nil); err != nil || code != http.StatusOK {
+		t.Fatalf("probe code=%d err=%v", code, err)
+	}
+	if cb.State(host) != BreakerClosed {
+		t.Fatalf("state = %s, want closed", cb.State(host))
+	}
+	want := []string{"closed>open", "open>half-open", "half-open>closed"}
+	if len(transitions) != len(want) {
+		t.Fatalf("transitions = %v, want %v", transitions, want)
+	}
+	for i := range want {
+		if transitions[i] != want[i] {
+			t.Fatalf("transitions = %v, want %v", transitions, want)
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailureReopens(t *testing.T) {
+	now := time.Now()
+	cb := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 1, CoolDown: time.Second})
+	cb.now = func() time.Time { return now }
+
+	fail := &http.Response{StatusCode: http.StatusBadGateway}
+	if err := cb.Allow("a"); err != nil {
+		t.Fatal(err)
+	}
+	cb.Record("a", fail, nil)
+	if cb.State("a") != BreakerOpen {
+		t.Fatalf("state = %s, want open", cb.State("a"))
+	}
+	if cb.State("b") != BreakerClosed {
+		t.Fatal("breaker must be per host")
+	}
+
+	now = now.Add(time.Second)
+	if err := cb.Allow("a"); err != nil {
+		t.Fatalf("half-open probe rejected: %v", err)
+	}
+	if err := cb.Allow("a"); err == nil {
+		t.Fatal("second half-open probe must be rejected")
+	}
+	cb.Record("a", fail, nil)
+	if cb.State("a") != BreakerOpen {
+		t.Fatalf("state = %s, want open again", cb.State("a"))
+	}
+}
+
+func TestCircuitBreakerRelease(t *testing.T) {
+	now := time.Now()
+	cb := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 2, FailureRatio: 0.5, CoolDown: time.Second})
+	cb.now = func() time.Time { return now }
+	fail := &http.Response{StatusCode: http.StatusBadGateway}
+
+	// released calls do not dilute the failure ratio
+	for i := 0; i < 5; i++ {
+		cb.Allow("a")
+		cb.Release("a")
+	}
+	cb.Allow("a")
+	cb.Record("a", fail, nil)
+	cb.Allow("a")
+	cb.Record("a", fail, nil)
+	if cb.State("a") != BreakerOpen {
+		t.Fatalf("state = %s, want open", cb.State("a"))
+	}
+
+	// a released probe frees its slot and does not close the breaker
+	now = now.Add(time.Second)
+	if err := cb.Allow("a"); err != nil {
+		t.Fatalf("half-open probe rejected: %v", err)
+	}
+	cb.Release("a")
+	if cb.State("a") != BreakerHalfOpen {
+		t.Fatalf("state = %s after released probe, want half-open", cb.State("a"))
+	}
+	if err := cb.Allow("a"); err != nil {
+		t.Fatalf("next probe rejected: %v", err)
+	}
+	cb.Record("a", &http.Response{StatusCode: http.StatusOK}, nil)
+	if cb.State("a") != BreakerClosed {
+		t.Fatalf("state = %s, want closed", cb.State("a"))
+	}
+}
+
+func TestCircuitBreakerTimeoutIsFailure(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+	host := mustHost(t, srv.URL)
+
+	cb := NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 3})
+	c := NewHttp()
+	c.SetCircuitBreaker(cb)
+
+	// the caller giving up says nothing about the host
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		c.GetCtx(ctx, srv.URL, nil, nil)
+		cancel()
+	}
+	if cb.State(host) != BreakerClosed {
+		t.Fatalf("state = %s after caller timeouts, want closed", cb.State(host))
+	}
+
+	// the client timeout is the host being too slow
+	c = NewHttp(WithTimeout(20 * time.Millisecond))
+	c.SetCircuitBreaker(cb)
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(srv.URL, nil, nil); err == nil {
+			t.Fatal("stalled server must time out")
+		}
+	}
+	if cb.State(host) != BreakerOpen {
+		t.Fatalf("state = %s after client timeouts, want open", cb.State(host))
+	}
+}
+
+func mustHost(t *testing.T, raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
@@ -0,0 +1,358 @@
+package httpclient
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/medatechnology/goutil/medattlmap"
+)
+
+// Opt-in response cache for GET, honoring Cache-Control and revalidating with
+// If-None-Match / If-Modified-Since.
+// Usage:
+//
+//	c := httpclient.NewHttp()
+//	c.SetCache(httpclient.NewResponseCache(nil)) // in memory, medattlmap.TTLMap
+//
+//	resp, err := c.DoResponse(ctx, "GET", url, nil, &catalog, nil)
+//	if resp.CacheHit { ... }
+//
+// A fresh entry (max-age, or Expires) is returned without calling the server.
+// A stale entry with ETag or Last-Modified is revalidated, a 304 reply is a cache
+// hit and refreshes the entry. no-store on the request or response skips the
+// cache. Only 200 responses are stored, keyed by the url with the client query
+// params applied and a hash of the Authorization and Cookie headers, so a
+// response fetched with one user's token is not served to another. The cache is private: share one ResponseCache only between
+// clients that see the same data (same credentials). The in memory store runs a
+// cleanup goroutine, call Close when the cache is no longer used:
+//
+//	cache := httpclient.NewResponseCache(nil)
+//	defer cache.Close()
+
+const (
+	DEFAULT_CACHE_STALE_TTL     = 24 * time.Hour // stale entries with validators are kept this long for revalidation
+	DEFAULT_CACHE_MAX_BODY_SIZE = 10 << 20       // 10MB, bigger responses are not stored
+)
+
+// CacheEntry is a stored response
+type CacheEntry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Vary       http.Header // request header values named by the Vary response header
+	StoredAt   time.Time
+	FreshUntil time.Time
+}
+
+// CacheStore keeps the entries, implement it for redis etc
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry, ttl time.Duration)
+	Delete(key string)
+}
+
+type ttlMapCacheStore struct {
+	m    *medattlmap.TTLMap
+	stop sync.Once
+}
+
+// NewTTLMapCacheStore stores entries in memory in a medattlmap.TTLMap. The
+// store implements io.Closer, Close stops the cleanup goroutine of the map.
+func NewTTLMapCacheStore() CacheStore {
+	return &ttlMapCacheStore{m: medattlmap.NewTTLMap(DEFAULT_CACHE_STALE_TTL, 0)}
+}
+
+func (s *ttlMapCacheStore) Get(key string) (*CacheEntry, bool) {
+	v, ok := s.m.Get(key)
+	if !ok {
+		return nil, false
+	}
+	return v.(*CacheEntry), true
+}
+
+func (s *ttlMapCacheStore) Set(key string, entry *CacheEntry, ttl time.Duration) {
+	// TTLMap counts in seconds and treats 0 as its default
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	s.m.Put(key, ttl, entry)
+}
+
+func (s *ttlMapCacheStore) Delete(key string) {
+	s.m.Delete(key)
+}
+
+// Close stops the cleanup goroutine, it is safe to call more than once
+func (s *ttlMapCacheStore) Close() error {
+	s.stop.Do(s.m.Stop)
+	return nil
+}
+
+type ResponseCache struct {
+	Store       CacheStore
+	StaleTTL    time.Duration // 0 uses DEFAULT_CACHE_STALE_TTL
+	MaxBodySize int64         // 0 uses DEFAULT_CACHE_MAX_BODY_SIZE
+	now         func() time.Time
+}
+
+// NewResponseCache creates the cache, nil store uses NewTTLMapCacheStore
+func NewResponseCache(store CacheStore) *ResponseCache {
+	if store == nil {
+		store = NewTTLMapCacheStore()
+	}
+	return &ResponseCache{Store: store, now: time.Now}
+}
+
+// Close closes the store when it implements io.Closer (the default in memory
+// store does). The cache must not be used afterwards.
+func (c *ResponseCache) Close() error {
+	if closer, ok := c.Store.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// SetCache enables the response cache for GET, nil disables it
+func (h *httpClient) SetCache(cache *ResponseCache) *httpClient {
+	h.mu.Lock()
+	h.cache = cache
+	h.mu.Unlock()
+	return h
+}
+
+// sendCached is send with the cache in front. The bool is true when the
+// response comes from the cache (fresh entry or 304 revalidation).
+func (h *httpClient) sendCached(request *http.Request) (*http.Response, bool, error) {
+	h.mu.RLock()
+	c := h.cache
+	h.mu.RUnlock()
+	if c == nil || request.Method != http.MethodGet || request.Body != nil ||
+		hasCacheDirective(request.Header, "no-store") {
+		response, err := h.send(request)
+		return response, false, err
+	}
+	key := cacheKey(request)
+	entry, found := c.Store.Get(key)
+	if found && !entry.varyMatches(request.Header) {
+		found = false
+	}
+	if found {
+		if c.clock().Before(entry.FreshUntil) && !hasCacheDirective(request.Header, "no-cache") {
+			return entry.response(request), true, nil
+		}
+		entry.setValidators(request.Header)
+	}
+
+	response, err := h.send(request)
+	if err != nil {
+		return nil, false, err
+	}
+	if found && response.StatusCode == http.StatusNotModified {
+		drainBody(response)
+		refreshed := *entry
+		refreshed.Header = entry.Header.Clone()
+		for k, v := range response.Header {
+			refreshed.Header[k] = v
+		}
+		c.store(key, &refreshed, request.Header)
+		return refreshed.response(request), true, nil
+	}
+	if response.StatusCode == http.StatusOK && !hasCacheDirective(response.Header, "no-store") &&
+		(freshness(response.Header, c.clock()) > 0 || hasValidators(response.Header)) {
+		c.storeResponse(key, request, response)
+	} else if found {
+		c.Store.Delete(key)
+	}
+	return response, false, nil
+}
+
+// cacheKey is the url, plus a hash of the credential headers when the request
+// has any. The hash keeps tokens out of the store keys.
+func cacheKey(request *http.Request) string {
+	key := request.URL.String()
+	authorization, cookies := request.Header.Values("Authorization"), request.Header.Values("Cookie")
+	if len(authorization) == 0 && len(cookies) == 0 {
+		return key
+	}
+	sum := sha256.Sum256([]byte(strings.Join(authorization, "\n") + "\x00" + strings.Join(cookies, "\n")))
+	return key + "#" + hex.EncodeToString(sum[:])
+}
+
+// storeResponse reads the body (up to MaxBodySize) into the cache and puts a
+// reader over the same bytes back on the response
+func (c *ResponseCache) storeResponse(key string, request *http.Request, response *http.Response) {
+	limit := c.MaxBodySize
+	if limit <= 0 {
+		limit = DEFAULT_CACHE_MAX_BODY_SIZE
+	}
+	if response.ContentLength > limit {
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(response.Body, limit+1))
+	if err != nil || int64(len(body)) > limit {
+		// too big or broken, hand back what was read plus the rest
+		response.Body = readCloser{io.MultiReader(bytes.NewReader(body), response.Body), response.Body}
+		return
+	}
+	response.Body.Close()
+	response.Body = io.NopCloser(bytes.NewReader(body))
+	c.store(key, &CacheEntry{
+		StatusCode: response.StatusCode,
+		Header:     response.Header.Clone(),
+		Body:       body,
+	}, request.Header)
+}
+
+func (c *ResponseCache) store(key string, entry *CacheEntry, requestHeader http.Header) {
+	now := c.clock()
+	entry.StoredAt = now
+	entry.FreshUntil = now
+	if !hasCacheDirective(entry.Header, "no-cache") {
+		entry.FreshUntil = now.Add(freshness(entry.Header, now))
+	}
+	entry.Vary = nil
+	for _, name := range varyNames(entry.Header) {
+		if entry.Vary == nil {
+			entry.Vary = http.Header{}
+		}
+		entry.Vary[http.CanonicalHeaderKey(name)] = requestHeader.Values(name)
+	}
+	ttl := entry.FreshUntil.Sub(now)
+	if hasValidators(entry.Header) {
+		staleTTL := c.StaleTTL
+		if staleTTL <= 0 {
+			staleTTL = DEFAULT_CACHE_STALE_TTL
+		}
+		ttl += staleTTL
+	}
+	if ttl <= 0 {
+		c.Store.Delete(key)
+		return
+	}
+	c.Store.Set(key, entry, ttl)
+}
+
+func (c *ResponseCache) clock() time.Time {
+	if c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
+// response rebuilds the *http.Response from the entry
+func (e *CacheEntry) response(request *http.Request) *http.Response {
+	return &http.Response{
+		StatusCode:    e.StatusCode,
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        e.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       request,
+	}
+}
+
+// setValidators adds the conditional headers, unless the caller set its own
+func (e *CacheEntry) setValidators(header http.Header) {
+	if etag := e.Header.Get("ETag"); etag != "" && header.Get("If-None-Match") == "" {
+		header.Set("If-None-Match", etag)
+	}
+	if modified := e.Header.Get("Last-Modified"); modified != "" && header.Get("If-Modified-Since") == "" {
+		header.Set("If-Modified-Since", modified)
+	}
+}
+
+func (e *CacheEntry) varyMatches(header http.Header) bool {
+	for _, name := range varyNames(e.Header) {
+		if name == "*" {
+			return false
+		}
+		if strings.Join(e.Vary.Values(name), ",") != strings.Join(header.Values(name), ",") {
+			return false
+		}
+	}
+	return true
+}
+
+func varyNames(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+func hasValidators(header http.Header) bool {
+	return header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+}
+
+// cacheDirectives parses Cache-Control into lower case names and their values
+func cacheDirectives(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
+
+func hasCacheDirective(header http.Header, name string) bool {
+	_, ok := cacheDirectives(header)[name]
+	return ok
+}
+
+// freshness is max-age minus Age, or Expires minus Date when there is no max-age
+func freshness(header http.Header, now time.Time) time.Duration {
+	var lifetime time.Duration
+	if maxAge, ok := cacheDirectives(header)["max-age"]; ok {
+		seconds, err := strconv.ParseInt(maxAge, 10, 64)
+		if err != nil || seconds <= 0 {
+			return 0
+		}
+		lifetime = time.Duration(seconds) * time.Second
+	} else if expires := header.Get("Expires"); expires != "" {
+		at, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		lifetime = at.Sub(date)
+	}
+	if age, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && age > 0 {
+		lifetime -= time.Duration(age) * time.Second
+	}
+	if lifetime < 0 {
+		return 0
+	}
+	return lifetime
+}
+
+// readCloser reads from one reader and closes another
+type readCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func (r readCloser) Close() error {
+	return r.closer.Close()
+}
@@ -0,0 +1,182 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheMaxAge(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", MIME_JSON)
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Write([]byte(`{"id":1,"name":"catalog"}`))
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	cache := NewResponseCache(nil)
+	cache.now = func() time.Time { return now }
+	c := NewHttp()
+	c.SetCache(cache)
+
+	for i, wantHit := range []bool{false, true} {
+		var user testUser
+		resp, err := c.DoResponse(context.Background(), http.MethodGet, srv.URL, nil, &user, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.CacheHit != wantHit || user.Name != "catalog" {
+			t.Fatalf("call %d: hit = %v, user = %+v", i, resp.CacheHit, user)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("calls = %d, fresh entry must not reach the server", calls)
+	}
+
+	now = now.Add(61 * time.Second)
+	resp, err := c.DoResponse(context.Background(), http.MethodGet, srv.URL, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.CacheHit || calls != 2 {
+		t.Fatalf("expired entry without validators: hit = %v, calls = %d", resp.CacheHit, calls)
+	}
+}
+
+func TestCacheRevalidatesWithETag(t *testing.T) {
+	var calls, notModified int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "no-cache")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&notModified, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", MIME_JSON)
+		w.Write([]byte(`{"id":2,"name":"config"}`))
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetCache(NewResponseCache(nil))
+	for i, wantHit := range []bool{false, true, true} {
+		var user testUser
+		resp, err := c.DoResponse(context.Background(), http.MethodGet, srv.URL, nil, &user, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.CacheHit != wantHit || resp.StatusCode != http.StatusOK || user.Name != "config" {
+			t.Fatalf("call %d: hit = %v, status = %d, user = %+v", i, resp.CacheHit, resp.StatusCode, user)
+		}
+	}
+	if calls != 3 || notModified != 2 {
+		t.Fatalf("calls = %d, 304s = %d, want 3 and 2", calls, notModified)
+	}
+}
+
+func TestCacheNoStore(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "no-store, max-age=60")
+		w.Header().Set("ETag", `"x"`)
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetCache(NewResponseCache(nil))
+	c.Get(srv.URL, nil, nil)
+	c.Get(srv.URL, nil, nil)
+	if calls != 2 {
+		t.Fatalf("calls = %d, no-store must not be cached", calls)
+	}
+}
+
+func TestCacheVary(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetCache(NewResponseCache(nil))
+	c.SetHeader(map[string][]string{"Accept-Language": {"en"}})
+	c.Get(srv.URL, nil, nil)
+	c.Get(srv.URL, nil, nil)
+	c.SetHeader(map[string][]string{"Accept-Language": {"id"}})
+	c.Get(srv.URL, nil, nil)
+	if calls != 2 {
+		t.Fatalf("calls = %d, want 2 (one per language)", calls)
+	}
+}
+
+func TestFreshness(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	cases := []struct {
+		header http.Header
+		want   time.Duration
+	}{
+		{http.Header{"Cache-Control": {"max-age=30"}}, 30 * time.Second},
+		{http.Header{"Cache-Control": {"max-age=30"}, "Age": {"10"}}, 20 * time.Second},
+		{http.Header{"Cache-Control": {"max-age=0"}}, 0},
+		{http.Header{"Expires": {now.Add(time.Minute).Format(http.TimeFormat)}, "Date": {now.Format(http.TimeFormat)}}, time.Minute},
+		{http.Header{"Expires": {"0"}}, 0},
+		{http.Header{}, 0},
+	}
+	for _, tc := range cases {
+		if got := freshness(tc.header, now); got != tc.want {
+			t.Errorf("freshness(%v) = %s, want %s", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestCacheClose(t *testing.T) {
+	cache := NewResponseCache(nil)
+	cache.Store.Set("k", &CacheEntry{StatusCode: http.StatusOK}, time.Minute)
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// a second Close must not panic on the already stopped map
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCachePerCredentials(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Content-Type", MIME_JSON)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(`{"user":"` + r.Header.Get("Authorization") + `"}`))
+	}))
+	defer srv.Close()
+
+	cache := NewResponseCache(nil)
+	defer cache.Close()
+	c := NewHttp()
+	c.SetCache(cache)
+	for _, token := range []string{"Bearer a", "Bearer b", "Bearer a"} {
+		var got map[string]string
+		if _, err := c.R().Header("Authorization", token).Result(&got).Get(srv.URL); err != nil {
+			t.Fatal(err)
+		}
+		if got["user"] != token {
+			t.Fatalf("token %q got response for %q", token, got["user"])
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("server calls = %d, want 2 (one per token)", calls)
+	}
+}
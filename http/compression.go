@@ -0,0 +1,239 @@
+package httpclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Opt-in compression: request bodies are compressed, Accept-Encoding is sent and
+// the response body is decompressed while it is read, for every verb and for
+// the stream methods.
+// Usage:
+//
+//	c := httpclient.NewHttp()
+//	c.SetCompression(httpclient.Compression{
+//		RequestEncoding: httpclient.ENCODING_ZSTD, // "" only negotiates the response
+//		Threshold:       4 << 10,                  // smaller bodies go out plain
+//	})
+//
+// Bodies already in memory (json, form) are compressed once and can be retried.
+// Streamed bodies (Multipart) are compressed through a pipe while they are sent,
+// their size is unknown so the threshold does not apply to them. Decompression
+// is streaming too, the body is never fully buffered. When the caller sets its
+// own Accept-Encoding the response is left as it is.
+
+const (
+	ENCODING_GZIP   = "gzip"
+	ENCODING_ZSTD   = "zstd"
+	ENCODING_BROTLI = "br"
+
+	DEFAULT_COMPRESSION_THRESHOLD = 1 << 10 // 1KB
+	// ZSTD_MAX_WINDOW caps the window a zstd response may ask for, RFC 8878
+	// advises 8MB for http. A frame asking more fails instead of allocating it.
+	ZSTD_MAX_WINDOW = 8 << 20
+)
+
+var supportedEncodings = []string{ENCODING_ZSTD, ENCODING_BROTLI, ENCODING_GZIP}
+
+type Compression struct {
+	RequestEncoding string   // gzip, zstd or br, empty leaves request bodies plain
+	Threshold       int      // bytes, 0 uses DEFAULT_COMPRESSION_THRESHOLD
+	AcceptEncodings []string // preferred first, nil accepts all supported
+}
+
+// SetCompression enables compression, a zero Compression only negotiates and
+// decompresses responses
+func (h *httpClient) SetCompression(compression Compression) *httpClient {
+	h.mu.Lock()
+	h.compression = &compression
+	h.mu.Unlock()
+	return h
+}
+
+func (c *Compression) threshold() int {
+	if c.Threshold <= 0 {
+		return DEFAULT_COMPRESSION_THRESHOLD
+	}
+	return c.Threshold
+}
+
+func (c *Compression) acceptEncoding() string {
+	if len(c.AcceptEncodings) == 0 {
+		return strings.Join(supportedEncodings, ", ")
+	}
+	return strings.Join(c.AcceptEncodings, ", ")
+}
+
+// compressPayload returns the compressed payload and its Content-Encoding, or
+// the payload unchanged and "" when it is not compressed
+func (c *Compression) compressPayload(p payload) (payload, string, error) {
+	if c == nil || c.RequestEncoding == "" || p.reader == nil {
+		return p, "", nil
+	}
+	if _, err := newEncoder(c.RequestEncoding, io.Discard); err != nil {
+		return p, "", err
+	}
+	if sized, ok := p.reader.(interface{ Len() int }); ok && p.getBody == nil {
+		// already in memory, compress once so retries can replay the bytes
+		if sized.Len() < c.threshold() {
+			return p, "", nil
+		}
+		var buf bytes.Buffer
+		w, _ := newEncoder(c.RequestEncoding, &buf)
+		if _, err := io.Copy(w, p.reader); err != nil {
+			return p, "", err
+		}
+		if err := w.Close(); err != nil {
+			return p, "", err
+		}
+		return payload{reader: bytes.NewReader(buf.Bytes()), contentType: p.contentType}, c.RequestEncoding, nil
+	}
+	compressed := payload{reader: c.pipe(p.reader), contentType: p.contentType}
+	if p.getBody != nil {
+		compressed.getBody = func() (io.ReadCloser, error) {
+			r, err := p.getBody()
+			if err != nil {
+				return nil, err
+			}
+			return c.pipe(r), nil
+		}
+	}
+	return compressed, c.RequestEncoding, nil
+}
+
+// pipe compresses r while it is read
+func (c *Compression) pipe(r io.Reader) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := newEncoder(c.RequestEncoding, pw)
+		if err == nil {
+			_, err = io.Copy(w, r)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if closer, ok := r.(io.Closer); ok {
+			closer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+type callerAcceptEncodingKey struct{}
+
+// markCallerAcceptEncoding remembers, once per call, that the caller set its
+// own Accept-Encoding. The header alone cannot tell it on a retry or in a
+// middleware, so the transport looks at this marker only.
+func markCallerAcceptEncoding(request *http.Request) *http.Request {
+	if request.Header.Get("Accept-Encoding") == "" {
+		return request
+	}
+	return request.WithContext(context.WithValue(request.Context(), callerAcceptEncodingKey{}, true))
+}
+
+// transport sets Accept-Encoding and decompresses the response body. The header
+// is set on a copy so the caller's request (replayed on retry, snapshot by the
+// cache for Vary) is left as it was.
+func (c *Compression) transport(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if own, _ := req.Context().Value(callerAcceptEncodingKey{}).(bool); own {
+			return next.RoundTrip(req)
+		}
+		req = req.WithContext(req.Context())
+		req.Header = req.Header.Clone()
+		req.Header.Set("Accept-Encoding", c.acceptEncoding())
+		resp, err := next.RoundTrip(req)
+		if err != nil || resp.Body == nil {
+			return resp, err
+		}
+		encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+		if encoding == "" || encoding == "identity" || !isSupportedEncoding(encoding) {
+			return resp, nil
+		}
+		resp.Body = &decodingBody{encoding: encoding, source: resp.Body}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+		return resp, nil
+	})
+}
+
+func isSupportedEncoding(encoding string) bool {
+	for _, supported := range supportedEncodings {
+		if encoding == supported {
+			return true
+		}
+	}
+	return false
+}
+
+func newEncoder(encoding string, w io.Writer) (io.WriteCloser, error) {
+	switch encoding {
+	case ENCODING_GZIP:
+		return gzip.NewWriter(w), nil
+	case ENCODING_ZSTD:
+		return zstd.NewWriter(w)
+	case ENCODING_BROTLI:
+		return brotli.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+// decodingBody creates the decoder on the first Read, so an empty body (HEAD,
+// 204) is not an error
+type decodingBody struct {
+	encoding string
+	source   io.ReadCloser
+	decoder  io.Reader
+	closeFn  func()
+	err      error
+}
+
+func (d *decodingBody) Read(p []byte) (int, error) {
+	if d.decoder == nil && d.err == nil {
+		d.init()
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.decoder.Read(p)
+}
+
+func (d *decodingBody) init() {
+	switch d.encoding {
+	case ENCODING_GZIP:
+		r, err := gzip.NewReader(d.source)
+		if err != nil {
+			d.err = err
+			return
+		}
+		d.decoder, d.closeFn = r, func() { r.Close() }
+	case ENCODING_ZSTD:
+		r, err := zstd.NewReader(d.source, zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxWindow(ZSTD_MAX_WINDOW), zstd.WithDecoderMaxMemory(ZSTD_MAX_WINDOW))
+		if err != nil {
+			d.err = err
+			return
+		}
+		d.decoder, d.closeFn = r, r.Close
+	case ENCODING_BROTLI:
+		d.decoder = brotli.NewReader(d.source)
+	}
+}
+
+func (d *decodingBody) Close() error {
+	if d.closeFn != nil {
+		d.closeFn()
+	}
+	return d.source.Close()
+}
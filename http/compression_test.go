@@ -0,0 +1,231 @@
+package httpclient
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compressionServer decodes the request body by Content-Encoding, replies with
+// the body and the encoding it saw, compressed with the first accepted encoding
+func compressionServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		switch r.Header.Get("Content-Encoding") {
+		case ENCODING_GZIP:
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("gzip: %v", err)
+				return
+			}
+			body = gz
+		case ENCODING_ZSTD:
+			zr, err := zstd.NewReader(r.Body)
+			if err != nil {
+				t.Errorf("zstd: %v", err)
+				return
+			}
+			defer zr.Close()
+			body = zr
+		case ENCODING_BROTLI:
+			body = brotli.NewReader(r.Body)
+		}
+		data, err := io.ReadAll(body)
+		if err != nil {
+			t.Errorf("read body: %v", err)
+		}
+		reply, _ := json.Marshal(map[string]string{
+			"request_encoding": r.Header.Get("Content-Encoding"),
+			"body":             string(data),
+		})
+
+		accept := strings.TrimSpace(strings.Split(r.Header.Get("Accept-Encoding"), ",")[0])
+		w.Header().Set("Content-Type", MIME_JSON)
+		if r.URL.Query().Get("plain") != "" || accept == "" {
+			w.Write(reply)
+			return
+		}
+		w.Header().Set("Content-Encoding", accept)
+		enc, err := newEncoder(accept, w)
+		if err != nil {
+			t.Errorf("encoder: %v", err)
+			return
+		}
+		enc.Write(reply)
+		enc.Close()
+	}))
+}
+
+func TestCompressionRoundTrip(t *testing.T) {
+	srv := compressionServer(t)
+	defer srv.Close()
+	large := strings.Repeat("compress me ", 200)
+
+	for _, encoding := range []string{ENCODING_GZIP, ENCODING_ZSTD, ENCODING_BROTLI} {
+		c := NewHttp()
+		c.SetHeader(map[string][]string{"Content-Type": {MIME_JSON}})
+		c.SetCompression(Compression{RequestEncoding: encoding, AcceptEncodings: []string{encoding}})
+
+		var got map[string]string
+		if _, err := c.Post(srv.URL, map[string]string{"text": large}, &got, nil); err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if got["request_encoding"] != encoding || !strings.Contains(got["body"], large) {
+			t.Fatalf("%s: got encoding %q, body len %d", encoding, got["request_encoding"], len(got["body"]))
+		}
+
+		// small bodies stay plain
+		got = nil
+		if _, err := c.Post(srv.URL, map[string]string{"text": "hi"}, &got, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got["request_encoding"] != "" {
+			t.Fatalf("%s: small body was compressed", encoding)
+		}
+	}
+}
+
+func TestCompressionStreams(t *testing.T) {
+	srv := compressionServer(t)
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetCompression(Compression{RequestEncoding: ENCODING_GZIP, Threshold: 1})
+	resp, err := c.PostStreamCtx(context.Background(), srv.URL, map[string]string{"q": "stream"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "" || !resp.Uncompressed {
+		t.Fatalf("stream response must be decoded: %v", resp.Header)
+	}
+	var got map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got["request_encoding"] != ENCODING_GZIP || got["body"] != `{"q":"stream"}` {
+		t.Fatalf("got = %v", got)
+	}
+
+	// a multipart body is compressed through the pipe
+	body := NewMultipart().Field("name", "report")
+	var echo map[string]string
+	if _, err := c.Post(srv.URL, body, &echo, nil); err != nil {
+		t.Fatal(err)
+	}
+	if echo["request_encoding"] != ENCODING_GZIP || !strings.Contains(echo["body"], `name="name"`) {
+		t.Fatalf("multipart echo = %v", echo)
+	}
+}
+
+func TestCompressionPlainResponseAndHead(t *testing.T) {
+	srv := compressionServer(t)
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetCompression(Compression{})
+	var got map[string]string
+	if _, err := c.Get(srv.URL+"?plain=1", &got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Head(srv.URL, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// gzipHandler replies with a gzip json body that echoes Accept-Encoding, the
+// first fail requests get a 503
+func gzipHandler(calls *int32, fail int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(calls, 1) <= fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", MIME_JSON)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Header().Set("Content-Encoding", ENCODING_GZIP)
+		gz := gzip.NewWriter(w)
+		gz.Write([]byte(`{"accept":"` + r.Header.Get("Accept-Encoding") + `"}`))
+		gz.Close()
+	}
+}
+
+func TestCompressionRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(gzipHandler(&calls, 1))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetRetryPolicy(fastRetryPolicy())
+	c.SetCompression(Compression{AcceptEncodings: []string{ENCODING_GZIP}})
+	var got map[string]string
+	if _, err := c.Get(srv.URL, &got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 || got["accept"] != ENCODING_GZIP {
+		t.Fatalf("calls = %d, got = %v", calls, got)
+	}
+}
+
+func TestCompressionCacheVary(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(gzipHandler(&calls, 0))
+	defer srv.Close()
+
+	cache := NewResponseCache(nil)
+	defer cache.Close()
+	c := NewHttp()
+	c.SetCache(cache)
+	c.SetCompression(Compression{AcceptEncodings: []string{ENCODING_GZIP}})
+	for i := 0; i < 3; i++ {
+		var got map[string]string
+		if _, err := c.Get(srv.URL, &got, nil); err != nil || got["accept"] != ENCODING_GZIP {
+			t.Fatalf("call %d: got = %v, err = %v", i, got, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("server calls = %d, want 1", calls)
+	}
+}
+
+func TestDecodingBodyEmpty(t *testing.T) {
+	for _, encoding := range supportedEncodings {
+		body := &decodingBody{encoding: encoding, source: io.NopCloser(bytes.NewReader(nil))}
+		data, _ := io.ReadAll(body)
+		body.Close()
+		if len(data) != 0 {
+			t.Errorf("%s: data = %q", encoding, data)
+		}
+	}
+}
+
+func TestDecodingBodyZstdWindowLimit(t *testing.T) {
+	// magic, no content size, window descriptor 64MB (log 26), one raw last
+	// block of 5 bytes
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, (26 - 10) << 3, 0x29, 0x00, 0x00}
+	frame = append(frame, "hello"...)
+	body := &decodingBody{encoding: ENCODING_ZSTD, source: io.NopCloser(bytes.NewReader(frame))}
+	defer body.Close()
+	if _, err := io.ReadAll(body); err == nil {
+		t.Fatal("zstd frame over ZSTD_MAX_WINDOW must be rejected")
+	}
+
+	// same frame with a 1MB window
+	frame[5] = (20 - 10) << 3
+	ok := &decodingBody{encoding: ENCODING_ZSTD, source: io.NopCloser(bytes.NewReader(frame))}
+	defer ok.Close()
+	if data, err := io.ReadAll(ok); err != nil || string(data) != "hello" {
+		t.Fatalf("data = %q, err = %v", data, err)
+	}
+}
@@ -0,0 +1,124 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/medatechnology/goutil/medaerror"
+)
+
+// HTTPError is returned by every verb method (and DoCtx/DoResponse) when the
+// server replies with status >= 400.
+// Usage:
+//
+//	_, err := c.Get(url, &result, &errBody)
+//	var httpErr *httpclient.HTTPError
+//	if errors.As(err, &httpErr) {
+//		fmt.Println(httpErr.StatusCode, httpErr.Header.Get("X-Request-ID"))
+//	}
+//	if errors.Is(err, httpclient.ErrNotFound) { ... }
+//	if errors.Is(err, httpclient.ErrServerError) { ... } // any 5xx
+//
+//	// for API handlers
+//	return httpErr.MedaError() // Code = http status
+
+const (
+	HTTP_ERROR_BODY_LIMIT = 4096 // max bytes of the body kept in HTTPError.Body
+)
+
+// Sentinel errors to use with errors.Is, HTTPError matches the one for its
+// status and the class (client/server) one.
+var (
+	ErrBadRequest          = errors.New("bad request")
+	ErrUnauthorized        = errors.New("unauthorized")
+	ErrForbidden           = errors.New("forbidden")
+	ErrNotFound            = errors.New("not found")
+	ErrConflict            = errors.New("conflict")
+	ErrTooManyRequests     = errors.New("too many requests")
+	ErrInternalServerError = errors.New("internal server error")
+	ErrServiceUnavailable  = errors.New("service unavailable")
+	ErrClientError         = errors.New("client error") // any 4xx
+	ErrServerError         = errors.New("server error") // any 5xx
+)
+
+var statusSentinels = map[int]error{
+	http.StatusBadRequest:          ErrBadRequest,
+	http.StatusUnauthorized:        ErrUnauthorized,
+	http.StatusForbidden:           ErrForbidden,
+	http.StatusNotFound:            ErrNotFound,
+	http.StatusConflict:            ErrConflict,
+	http.StatusTooManyRequests:     ErrTooManyRequests,
+	http.StatusInternalServerError: ErrInternalServerError,
+	http.StatusServiceUnavailable:  ErrServiceUnavailable,
+}
+
+type HTTPError struct {
+	StatusCode StatusCode
+	Status     string // ie: "404 Not Found"
+	Method     string
+	URL        string
+	Header     http.Header
+	Body       []byte      // raw body, truncated to HTTP_ERROR_BODY_LIMIT
+	Truncated  bool        // true if Body was cut
+	Payload    interface{} // the errorResponse passed by the caller if the body was json and decoded, else nil
+	DecodeErr  error       // set when the body was json but did not fit errorResponse, Payload is then nil
+}
+
+func newHTTPError(resp *Response, payload interface{}) *HTTPError {
+	body := resp.Body
+	truncated := false
+	if len(body) > HTTP_ERROR_BODY_LIMIT {
+		body = body[:HTTP_ERROR_BODY_LIMIT]
+		truncated = true
+	}
+	return &HTTPError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Method:     resp.Method,
+		URL:        resp.URL,
+		Header:     resp.Header,
+		Body:       body,
+		Truncated:  truncated,
+		Payload:    payload,
+	}
+}
+
+func (e *HTTPError) Error() string {
+	status := e.Status
+	if status == "" {
+		status = fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(int(e.StatusCode)))
+	}
+	if len(e.Body) == 0 {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.URL, status)
+	}
+	return fmt.Sprintf("%s %s: %s: %s", e.Method, e.URL, status, string(e.Body))
+}
+
+// Is matches the sentinel for the exact status and the 4xx/5xx class
+func (e *HTTPError) Is(target error) bool {
+	if sentinel, ok := statusSentinels[int(e.StatusCode)]; ok && sentinel == target {
+		return true
+	}
+	switch target {
+	case ErrClientError:
+		return e.StatusCode >= 400 && e.StatusCode < 500
+	case ErrServerError:
+		return e.StatusCode >= 500
+	}
+	return false
+}
+
+// Unwrap returns the error of decoding the body into errorResponse, if any
+func (e *HTTPError) Unwrap() error {
+	return e.DecodeErr
+}
+
+// MedaError wraps the error into medaerror.MedaError with Code = http status,
+// the body as ResponseString and the decoded payload as Data. The HTTPError is
+// kept in Err so errors.Is/As still work on the result.
+func (e *HTTPError) MedaError() medaerror.MedaError {
+	merr := medaerror.NewMedaErr(int(e.StatusCode), e.Error(), string(e.Body), e.Payload)
+	merr.Err = e
+	return merr
+}
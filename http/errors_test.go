@@ -0,0 +1,97 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/medatechnology/goutil/medaerror"
+)
+
+func TestHTTPErrorClasses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"gone"}`))
+		case "/big":
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte(strings.Repeat("x", HTTP_ERROR_BODY_LIMIT+10)))
+		}
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	var errBody struct {
+		Message string `json:"message"`
+	}
+	code, err := c.Get(srv.URL+"/missing", nil, &errBody)
+	if code != http.StatusNotFound || !errors.Is(err, ErrNotFound) || !errors.Is(err, ErrClientError) {
+		t.Fatalf("code=%d err=%v", code, err)
+	}
+	if errors.Is(err, ErrServerError) || errors.Is(err, ErrUnauthorized) {
+		t.Fatal("404 must not match other sentinels")
+	}
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("err = %T, want *HTTPError", err)
+	}
+	if httpErr.Method != http.MethodGet || !strings.HasSuffix(httpErr.URL, "/missing") || httpErr.Payload == nil {
+		t.Fatalf("httpErr = %+v", httpErr)
+	}
+	if errBody.Message != "gone" {
+		t.Fatalf("errorResponse not decoded: %+v", errBody)
+	}
+
+	merr := httpErr.MedaError()
+	if merr.Code != http.StatusNotFound || !errors.Is(merr, ErrNotFound) {
+		t.Fatalf("MedaError = %s", merr.String())
+	}
+	var asMeda medaerror.MedaError
+	if !errors.As(error(merr), &asMeda) || asMeda.Data == nil {
+		t.Fatal("MedaError must keep the payload as Data")
+	}
+
+	_, err = c.Get(srv.URL+"/big", nil, nil)
+	if !errors.As(err, &httpErr) || !errors.Is(err, ErrServerError) {
+		t.Fatalf("err = %v", err)
+	}
+	if len(httpErr.Body) != HTTP_ERROR_BODY_LIMIT || !httpErr.Truncated {
+		t.Fatalf("body len = %d truncated = %v", len(httpErr.Body), httpErr.Truncated)
+	}
+}
+
+func TestHTTPErrorWhenErrorBodyDoesNotDecode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MIME_JSON)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`["oops"]`))
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetRetryPolicy(fastRetryPolicy())
+	var errBody struct {
+		Message string `json:"message"`
+	}
+	code, err := c.Get(srv.URL, nil, &errBody)
+	var httpErr *HTTPError
+	if code != http.StatusInternalServerError || !errors.As(err, &httpErr) || !errors.Is(err, ErrServerError) {
+		t.Fatalf("code = %d, err = %v, want HTTPError 500", code, err)
+	}
+	var typeErr *json.UnmarshalTypeError
+	if httpErr.Payload != nil || !errors.As(err, &typeErr) || string(httpErr.Body) != `["oops"]` {
+		t.Fatalf("payload = %v, decode err = %v, body = %s", httpErr.Payload, httpErr.DecodeErr, httpErr.Body)
+	}
+
+	_, resp, err := GetJSONWithError[testUser, testUser](context.Background(), c, srv.URL)
+	var apiErr *APIError[testUser]
+	if !errors.As(err, &apiErr) || resp.StatusCode != http.StatusInternalServerError || !errors.Is(err, ErrServerError) {
+		t.Fatalf("typed err = %v", err)
+	}
+}
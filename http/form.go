@@ -0,0 +1,110 @@
+package httpclient
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/medatechnology/goutil/object"
+)
+
+// formValues converts a body into url.Values for x-www-form-urlencoded (and
+// Multipart.Fields). Accepts url.Values, map[string]string, map[string][]string,
+// map[string]interface{} and structs (json or db tags, via object.StructToMap).
+// Numbers, bools, times (RFC3339) and fmt.Stringer are formatted, slices and
+// arrays become repeated keys (a=1&a=2).
+func formValues(body interface{}) url.Values {
+	data := url.Values{}
+	switch b := body.(type) {
+	case url.Values:
+		for k, v := range b {
+			data[k] = append([]string(nil), v...)
+		}
+		return data
+	case map[string][]string:
+		for k, v := range b {
+			data[k] = append([]string(nil), v...)
+		}
+		return data
+	case map[string]string:
+		for k, v := range b {
+			data.Set(k, v)
+		}
+		return data
+	case map[string]interface{}:
+		for k, v := range b {
+			addFormValue(data, k, v)
+		}
+		return data
+	}
+	for k, v := range object.StructToMap(body) {
+		addFormValue(data, k, v)
+	}
+	return data
+}
+
+func addFormValue(data url.Values, key string, v interface{}) {
+	if v == nil {
+		return
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return
+		}
+		addFormValue(data, key, rv.Elem().Interface())
+		return
+	}
+	// []byte is a value, not a list
+	if (rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array) && rv.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < rv.Len(); i++ {
+			addFormValue(data, key, rv.Index(i).Interface())
+		}
+		return
+	}
+	data.Add(key, formatFormValue(v))
+}
+
+func formatFormValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case []byte:
+		return string(val)
+	case bool:
+		return strconv.FormatBool(val)
+	case time.Time:
+		return val.UTC().Format(time.RFC3339)
+	case fmt.Stringer:
+		return val.String()
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Float32:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 32)
+	case reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, 64)
+	case reflect.String:
+		return rv.String()
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool())
+	}
+	return fmt.Sprint(v)
+}
+
+// sortedKeys so multipart fields are written in a stable order
+func sortedKeys(values url.Values) []string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
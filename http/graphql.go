@@ -0,0 +1,258 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GraphQL over http POST, on top of HttpClient (so retry, auth, tracing etc
+// apply).
+// Usage:
+//
+//	gql := httpclient.NewGraphQL(c, "https://api.example.com/graphql")
+//
+//	type UserData struct {
+//		User struct{ ID, Name string } `json:"user"`
+//	}
+//	data, err := httpclient.GraphQLQuery[UserData](ctx, gql,
+//		`query($id: ID!) { user(id: $id) { id name } }`, map[string]any{"id": "1"})
+//	var gqlErrs httpclient.GraphQLErrors
+//	if errors.As(err, &gqlErrs) {
+//		for _, e := range gqlErrs {
+//			fmt.Println(e.PathString(), e.Message, e.Code())
+//		}
+//	}
+//
+//	// several operations in one http request (the server must support batching)
+//	results, err := gql.Batch(ctx, req1, req2)
+//	user, err := httpclient.DecodeGraphQLData[UserData](results[0])
+//
+// With SetPersistedQueries(true) only the sha256 hash of the query is sent
+// first (Apollo automatic persisted queries), the full query is sent once when
+// the server does not know the hash yet. When the response has both data and
+// errors (partial result) the decoded data is returned together with the
+// GraphQLErrors.
+
+const (
+	GRAPHQL_PERSISTED_QUERY_NOT_FOUND = "PersistedQueryNotFound"
+)
+
+var ErrGraphQL = errors.New("graphql error")
+
+type GraphQLRequest struct {
+	Query         string                 `json:"query,omitempty"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     interface{}            `json:"variables,omitempty"` // map or struct
+	Extensions    map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type GraphQLLocation struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// GraphQLError is one entry of the errors array
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"` // field names (string) and list indexes (float64)
+	Locations  []GraphQLLocation      `json:"locations,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+func (e GraphQLError) Error() string {
+	if path := e.PathString(); path != "" {
+		return path + ": " + e.Message
+	}
+	return e.Message
+}
+
+func (e GraphQLError) Is(target error) bool {
+	return target == ErrGraphQL
+}
+
+// PathString joins the path with dots, ie: "user.friends.0.name"
+func (e GraphQLError) PathString() string {
+	parts := make([]string, len(e.Path))
+	for i, p := range e.Path {
+		switch v := p.(type) {
+		case string:
+			parts[i] = v
+		case float64:
+			parts[i] = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			parts[i] = fmt.Sprint(v)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+// Code returns extensions.code (ie: "UNAUTHENTICATED"), empty when not set
+func (e GraphQLError) Code() string {
+	code, _ := e.Extensions["code"].(string)
+	return code
+}
+
+// GraphQLErrors is the errors array as one error, errors.Is/As see every entry
+type GraphQLErrors []GraphQLError
+
+func (e GraphQLErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return "graphql: " + strings.Join(messages, "; ")
+}
+
+func (e GraphQLErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// GraphQLResponse is the decoded response of one operation
+type GraphQLResponse[T any] struct {
+	Data       T                      `json:"data"`
+	Errors     GraphQLErrors          `json:"errors,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+type GraphQLClient struct {
+	client           HttpClient
+	endpoint         string
+	persistedQueries bool
+}
+
+func NewGraphQL(c HttpClient, endpoint string) *GraphQLClient {
+	return &GraphQLClient{client: c, endpoint: endpoint}
+}
+
+// SetPersistedQueries sends the query hash instead of the query (Apollo APQ)
+func (g *GraphQLClient) SetPersistedQueries(enabled bool) *GraphQLClient {
+	g.persistedQueries = enabled
+	return g
+}
+
+// GraphQLQueryHash is the sha256 hex of the query, used as persisted query id
+func GraphQLQueryHash(query string) string {
+	sum := sha256.Sum256([]byte(query))
+	return hex.EncodeToString(sum[:])
+}
+
+// GraphQLQuery runs a query and decodes data into T
+func GraphQLQuery[T any](ctx context.Context, g *GraphQLClient, query string, variables interface{}) (T, error) {
+	resp, _, err := GraphQLDo[T](ctx, g, GraphQLRequest{Query: query, Variables: variables})
+	if resp == nil {
+		var zero T
+		return zero, err
+	}
+	return resp.Data, err
+}
+
+// GraphQLMutate runs a mutation and decodes data into T
+func GraphQLMutate[T any](ctx context.Context, g *GraphQLClient, mutation string, variables interface{}) (T, error) {
+	return GraphQLQuery[T](ctx, g, mutation, variables)
+}
+
+// GraphQLDo runs one operation. err is GraphQLErrors when the response has
+// errors, the returned GraphQLResponse then still holds the (partial) data.
+func GraphQLDo[T any](ctx context.Context, g *GraphQLClient, req GraphQLRequest) (*GraphQLResponse[T], *Response, error) {
+	raw, resp, err := g.do(ctx, req)
+	if raw == nil {
+		return nil, resp, err
+	}
+	out := &GraphQLResponse[T]{Errors: raw.Errors, Extensions: raw.Extensions}
+	data, decodeErr := DecodeGraphQLData[T](*raw)
+	if decodeErr != nil && err == nil {
+		err = decodeErr
+	}
+	out.Data = data
+	return out, resp, err
+}
+
+// DecodeGraphQLData decodes data of a raw response (ie: from Batch) into T
+func DecodeGraphQLData[T any](resp GraphQLResponse[json.RawMessage]) (T, error) {
+	var data T
+	if len(resp.Data) == 0 || bytes.Equal(resp.Data, []byte("null")) {
+		return data, nil
+	}
+	err := json.Unmarshal(resp.Data, &data)
+	return data, err
+}
+
+// Batch sends the operations as one json array, the responses come back in
+// the same order. Errors of each operation are in its Errors, err is only for
+// transport/http failures.
+func (g *GraphQLClient) Batch(ctx context.Context, reqs ...GraphQLRequest) ([]GraphQLResponse[json.RawMessage], error) {
+	var results []GraphQLResponse[json.RawMessage]
+	var errBody json.RawMessage
+	_, err := g.client.DoResponse(ctx, http.MethodPost, g.endpoint, JSONBody(reqs), &results, &errBody)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) != len(reqs) {
+		return nil, fmt.Errorf("graphql: batch of %d operations got %d results", len(reqs), len(results))
+	}
+	return results, nil
+}
+
+// do sends one operation, with the persisted query round trip when enabled
+func (g *GraphQLClient) do(ctx context.Context, req GraphQLRequest) (*GraphQLResponse[json.RawMessage], *Response, error) {
+	if g.persistedQueries && req.Query != "" {
+		hashed := req
+		hashed.Extensions = withPersistedQuery(req.Extensions, GraphQLQueryHash(req.Query))
+		hashed.Query = ""
+		raw, resp, err := g.send(ctx, hashed)
+		if raw == nil || !persistedQueryNotFound(raw.Errors) {
+			return raw, resp, err
+		}
+		// the server does not know the hash yet, register it with the full query
+		req.Extensions = hashed.Extensions
+	}
+	return g.send(ctx, req)
+}
+
+func (g *GraphQLClient) send(ctx context.Context, req GraphQLRequest) (*GraphQLResponse[json.RawMessage], *Response, error) {
+	var raw GraphQLResponse[json.RawMessage]
+	var errBody GraphQLResponse[json.RawMessage]
+	resp, err := g.client.DoResponse(ctx, http.MethodPost, g.endpoint, JSONBody(req), &raw, &errBody)
+	if err != nil {
+		var httpErr *HTTPError
+		// servers reply 400 (or 200) with the errors array for invalid queries
+		if errors.As(err, &httpErr) && httpErr.DecodeErr == nil && len(errBody.Errors) > 0 {
+			return &errBody, resp, errBody.Errors
+		}
+		return nil, resp, err
+	}
+	if len(raw.Errors) > 0 {
+		return &raw, resp, raw.Errors
+	}
+	return &raw, resp, nil
+}
+
+func withPersistedQuery(extensions map[string]interface{}, hash string) map[string]interface{} {
+	merged := make(map[string]interface{}, len(extensions)+1)
+	for k, v := range extensions {
+		merged[k] = v
+	}
+	merged["persistedQuery"] = map[string]interface{}{"version": 1, "sha256Hash": hash}
+	return merged
+}
+
+func persistedQueryNotFound(errs GraphQLErrors) bool {
+	for _, e := range errs {
+		if e.Message == GRAPHQL_PERSISTED_QUERY_NOT_FOUND || e.Code() == "PERSISTED_QUERY_NOT_FOUND" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,168 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+type gqlUserData struct {
+	User struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"user"`
+}
+
+// graphQLServer answers user queries, knows persisted queries once registered
+// and accepts batches
+func graphQLServer(t *testing.T, full *int32) *httptest.Server {
+	known := map[string]bool{}
+	answer := func(req GraphQLRequest) map[string]interface{} {
+		if pq, ok := req.Extensions["persistedQuery"].(map[string]interface{}); ok {
+			hash := pq["sha256Hash"].(string)
+			if req.Query == "" && !known[hash] {
+				return map[string]interface{}{"errors": []map[string]interface{}{{"message": GRAPHQL_PERSISTED_QUERY_NOT_FOUND}}}
+			}
+			if req.Query != "" {
+				if GraphQLQueryHash(req.Query) != hash {
+					t.Errorf("hash mismatch")
+				}
+				known[hash] = true
+			}
+		}
+		if req.Query != "" {
+			atomic.AddInt32(full, 1)
+		}
+		vars, _ := req.Variables.(map[string]interface{})
+		id, _ := vars["id"].(string)
+		if id == "missing" {
+			return map[string]interface{}{
+				"data": map[string]interface{}{"user": nil},
+				"errors": []map[string]interface{}{{
+					"message":    "user not found",
+					"path":       []interface{}{"user", 0, "name"},
+					"extensions": map[string]interface{}{"code": "NOT_FOUND"},
+				}},
+			}
+		}
+		return map[string]interface{}{"data": map[string]interface{}{"user": map[string]string{"id": id, "name": "user " + id}}}
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", MIME_JSON)
+		if strings.HasPrefix(strings.TrimSpace(string(body)), "[") {
+			var reqs []GraphQLRequest
+			json.Unmarshal(body, &reqs)
+			var out []map[string]interface{}
+			for _, req := range reqs {
+				out = append(out, answer(req))
+			}
+			json.NewEncoder(w).Encode(out)
+			return
+		}
+		var req GraphQLRequest
+		json.Unmarshal(body, &req)
+		if strings.Contains(req.Query, "syntax error") {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"errors": []map[string]string{{"message": "Syntax Error"}}})
+			return
+		}
+		json.NewEncoder(w).Encode(answer(req))
+	}))
+}
+
+const gqlUserQuery = `query($id: ID!) { user(id: $id) { id name } }`
+
+func TestGraphQLQuery(t *testing.T) {
+	var full int32
+	srv := graphQLServer(t, &full)
+	defer srv.Close()
+	gql := NewGraphQL(NewHttp(), srv.URL)
+	ctx := context.Background()
+
+	data, err := GraphQLQuery[gqlUserData](ctx, gql, gqlUserQuery, map[string]interface{}{"id": "7"})
+	if err != nil || data.User.Name != "user 7" {
+		t.Fatalf("data = %+v, err = %v", data, err)
+	}
+
+	_, err = GraphQLQuery[gqlUserData](ctx, gql, gqlUserQuery, map[string]interface{}{"id": "missing"})
+	var gqlErrs GraphQLErrors
+	if !errors.As(err, &gqlErrs) || !errors.Is(err, ErrGraphQL) || len(gqlErrs) != 1 {
+		t.Fatalf("err = %v, want GraphQLErrors", err)
+	}
+	if gqlErrs[0].PathString() != "user.0.name" || gqlErrs[0].Code() != "NOT_FOUND" {
+		t.Fatalf("error = %+v", gqlErrs[0])
+	}
+	var one GraphQLError
+	if !errors.As(err, &one) || one.Message != "user not found" {
+		t.Fatalf("errors.As single = %+v", one)
+	}
+
+	// 400 with errors array is GraphQLErrors too
+	_, err = GraphQLMutate[gqlUserData](ctx, gql, "mutation { syntax error", nil)
+	if !errors.As(err, &gqlErrs) || gqlErrs[0].Message != "Syntax Error" {
+		t.Fatalf("err = %v, want Syntax Error", err)
+	}
+}
+
+func TestGraphQLPersistedQueries(t *testing.T) {
+	var full int32
+	srv := graphQLServer(t, &full)
+	defer srv.Close()
+	gql := NewGraphQL(NewHttp(), srv.URL).SetPersistedQueries(true)
+
+	for i := 0; i < 3; i++ {
+		data, err := GraphQLQuery[gqlUserData](context.Background(), gql, gqlUserQuery, map[string]interface{}{"id": "1"})
+		if err != nil || data.User.ID != "1" {
+			t.Fatalf("call %d: data = %+v, err = %v", i, data, err)
+		}
+	}
+	if full != 1 {
+		t.Fatalf("full query sent %d times, want once (to register the hash)", full)
+	}
+}
+
+func TestGraphQLBatch(t *testing.T) {
+	var full int32
+	srv := graphQLServer(t, &full)
+	defer srv.Close()
+	gql := NewGraphQL(NewHttp(), srv.URL)
+
+	results, err := gql.Batch(context.Background(),
+		GraphQLRequest{Query: gqlUserQuery, Variables: map[string]interface{}{"id": "1"}},
+		GraphQLRequest{Query: gqlUserQuery, Variables: map[string]interface{}{"id": "missing"}},
+	)
+	if err != nil || len(results) != 2 {
+		t.Fatalf("results = %d, err = %v", len(results), err)
+	}
+	first, err := DecodeGraphQLData[gqlUserData](results[0])
+	if err != nil || first.User.Name != "user 1" {
+		t.Fatalf("first = %+v, err = %v", first, err)
+	}
+	if len(results[1].Errors) != 1 || results[1].Errors[0].Code() != "NOT_FOUND" {
+		t.Fatalf("second errors = %+v", results[1].Errors)
+	}
+}
+
+func TestGraphQLUndecodableErrorBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MIME_JSON)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`["oops"]`))
+	}))
+	defer srv.Close()
+	c := NewHttp()
+	c.SetRetryPolicy(fastRetryPolicy())
+
+	_, err := GraphQLQuery[gqlUserData](context.Background(), NewGraphQL(c, srv.URL), gqlUserQuery, nil)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusBadGateway || errors.Is(err, ErrGraphQL) {
+		t.Fatalf("err = %v, want HTTPError 502", err)
+	}
+}
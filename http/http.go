@@ -2,17 +2,23 @@ package httpclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 
 	// "github.com/thoas/go-funk"
 	"github.com/medatechnology/goutil/object"
 )
 
+const (
+	MIME_JSON = "application/json"
+	MIME_FORM = "application/x-www-form-urlencoded"
+)
+
 // http status code
 type StatusCode int
 type basicAuth struct {
@@ -20,16 +26,34 @@ type basicAuth struct {
 	Password string
 }
 type httpClient struct {
-	client        *http.Client
-	headers       map[string][]string
-	params        map[string]string
-	useBasicAuth  bool
-	basicAuthData basicAuth
+	client           *http.Client
+	mu               sync.RWMutex // guards every field below, setters can run while calls are in flight
+	headers          map[string][]string
+	params           map[string]string
+	useBasicAuth     bool
+	basicAuthData    basicAuth
+	retry            *RetryPolicy    // nil means no retry
+	breaker          *CircuitBreaker // nil means no circuit breaker
+	middlewares      []Middleware
+	auth             Authenticator // nil means only basic auth (if set)
+	limiter          *RateLimiter  // client wide, nil means no limit
+	hostLimiters     map[string]*RateLimiter
+	hostLimitersMu   sync.RWMutex
+	cache            *ResponseCache   // nil means no response cache
+	compression      *Compression     // nil means no compression
+	instrumentation  *Instrumentation // nil means no tracing and metrics
+	maxResponseSize  int64            // 0 uses DEFAULT_MAX_RESPONSE_SIZE, < 0 means no limit
+	maxErrorBodySize int64            // 0 uses DEFAULT_MAX_ERROR_BODY_SIZE, < 0 means no limit
 }
 
-func NewHttp() HttpClient {
+// NewHttp creates the client, see Option for timeouts and transport tuning
+func NewHttp(opts ...Option) HttpClient {
+	var options clientOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
 	return &httpClient{
-		client:  &http.Client{},
+		client:  options.build(),
 		headers: make(map[string][]string),
 		params:  make(map[string]string),
 	}
@@ -38,217 +62,427 @@ func NewHttp() HttpClient {
 type HttpClient interface {
 	Post(urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error)
 	Get(urll string, result interface{}, errorResponse interface{}) (StatusCode, error)
+	Put(urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error)
+	Patch(urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error)
+	Delete(urll string, result interface{}, errorResponse interface{}) (StatusCode, error)
+	Head(urll string, errorResponse interface{}) (StatusCode, error)
+	Options(urll string, result interface{}, errorResponse interface{}) (StatusCode, error)
+	// Do sends a request with any method, all the verb methods above use this
+	Do(method, urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error)
 	SetHeader(headers map[string][]string) *httpClient
 	SetQueryParams(params map[string]string) *httpClient
 	SetBasicAuth(username, password string) *httpClient
+	SetRetryPolicy(policy RetryPolicy) *httpClient
+	SetCircuitBreaker(cb *CircuitBreaker) *httpClient
+	SetAuthenticator(auth Authenticator) *httpClient
+	SetRateLimiter(l *RateLimiter) *httpClient
+	SetHostRateLimiter(host string, l *RateLimiter) *httpClient
+	SetCache(cache *ResponseCache) *httpClient
+	SetCompression(compression Compression) *httpClient
+	SetInstrumentation(instrumentation Instrumentation) *httpClient
+	SetMaxResponseSize(size int64) *httpClient
+	SetMaxErrorBodySize(size int64) *httpClient
+	// R starts a request with its own headers, query and path params, safe to
+	// use from many goroutines on a shared client
+	R() *RequestBuilder
+	// Use adds request/response interceptors, see Middleware
+	Use(middleware ...Middleware) *httpClient
 	// PostStream sends a POST request and returns raw response for streaming
 	PostStream(url string, data any) (*http.Response, error)
 	// GetStream sends a GET request and returns raw response for streaming
 	GetStream(url string) (*http.Response, error)
+
+	// Context-aware variants. The context is attached to the outgoing request so
+	// cancellation and deadlines apply to the round trip and to reading the body.
+	PostCtx(ctx context.Context, urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error)
+	GetCtx(ctx context.Context, urll string, result interface{}, errorResponse interface{}) (StatusCode, error)
+	PutCtx(ctx context.Context, urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error)
+	PatchCtx(ctx context.Context, urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error)
+	DeleteCtx(ctx context.Context, urll string, result interface{}, errorResponse interface{}) (StatusCode, error)
+	HeadCtx(ctx context.Context, urll string, errorResponse interface{}) (StatusCode, error)
+	OptionsCtx(ctx context.Context, urll string, result interface{}, errorResponse interface{}) (StatusCode, error)
+	DoCtx(ctx context.Context, method, urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error)
+	PostStreamCtx(ctx context.Context, url string, data any) (*http.Response, error)
+	GetStreamCtx(ctx context.Context, url string) (*http.Response, error)
+	// DoResponse is DoCtx returning the rich Response, used by the generic helpers (GetJSON etc)
+	DoResponse(ctx context.Context, method, urll string, body interface{}, result interface{}, errorResponse interface{}) (*Response, error)
 }
 
+// Post is PostCtx with context.Background()
 func (h *httpClient) Post(urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error) {
-	var payload *bytes.Buffer
-	if body != nil {
-		// jika content typenya merupakan application json
-		// maka akan di encode menjadi string menggunakan jsonENcode
-		// if funk.ContainsString(h.headers["Content-Type"], "application/json") {
-		if object.ArrayAContainsBString(h.headers["Content-Type"], "application/json") {
-			_body, err := h.marshalPayload(body)
-			if err != nil {
-				return StatusCode(0), err
-			}
-			payload = bytes.NewBufferString(string(_body))
-			// } else if funk.ContainsString(h.headers["Content-Type"], "application/x-www-form-urlencoded") {
-		} else if object.ArrayAContainsBString(h.headers["Content-Type"], "application/x-www-form-urlencoded") {
-			//  Jika body requestnya merupakan url-encoded
-			// data akan di set pada url values lalau di encode menajdi string
-			_body := object.StructToMap(body)
-			data := url.Values{}
-			for k, v := range _body {
-				if val, ok := v.(string); ok {
-					data.Set(k, val)
-				}
-			}
-			payload = bytes.NewBufferString(data.Encode())
-		}
-	}
-	request := &http.Request{}
-	if payload != nil {
-		req, err := http.NewRequest(http.MethodPost, urll, payload)
-		if err != nil {
+	return h.PostCtx(context.Background(), urll, body, result, errorResponse)
+}
 
-			return 0, err
-		}
-		request = req
-	} else {
-		req, err := http.NewRequest(http.MethodPost, urll, nil)
-		if err != nil {
-			return 0, err
-		}
-		request = req
+// PostCtx sends a POST request bound to ctx. The body is encoded based on the
+// Content-Type header (json or x-www-form-urlencoded).
+func (h *httpClient) PostCtx(ctx context.Context, urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.DoCtx(ctx, http.MethodPost, urll, body, result, errorResponse)
+}
+
+// Get is GetCtx with context.Background()
+func (h *httpClient) Get(urll string, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.GetCtx(context.Background(), urll, result, errorResponse)
+}
+
+// GetCtx sends a GET request bound to ctx.
+func (h *httpClient) GetCtx(ctx context.Context, urll string, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.DoCtx(ctx, http.MethodGet, urll, nil, result, errorResponse)
+}
+
+// Put is PutCtx with context.Background()
+func (h *httpClient) Put(urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.PutCtx(context.Background(), urll, body, result, errorResponse)
+}
+
+// PutCtx sends a PUT request bound to ctx, body is encoded the same way as Post.
+func (h *httpClient) PutCtx(ctx context.Context, urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.DoCtx(ctx, http.MethodPut, urll, body, result, errorResponse)
+}
+
+// Patch is PatchCtx with context.Background()
+func (h *httpClient) Patch(urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.PatchCtx(context.Background(), urll, body, result, errorResponse)
+}
+
+// PatchCtx sends a PATCH request bound to ctx, body is encoded the same way as Post.
+func (h *httpClient) PatchCtx(ctx context.Context, urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.DoCtx(ctx, http.MethodPatch, urll, body, result, errorResponse)
+}
+
+// Delete is DeleteCtx with context.Background()
+func (h *httpClient) Delete(urll string, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.DeleteCtx(context.Background(), urll, result, errorResponse)
+}
+
+// DeleteCtx sends a DELETE request bound to ctx. If the server needs a body on
+// DELETE, use DoCtx instead.
+func (h *httpClient) DeleteCtx(ctx context.Context, urll string, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.DoCtx(ctx, http.MethodDelete, urll, nil, result, errorResponse)
+}
+
+// Head is HeadCtx with context.Background()
+func (h *httpClient) Head(urll string, errorResponse interface{}) (StatusCode, error) {
+	return h.HeadCtx(context.Background(), urll, errorResponse)
+}
+
+// HeadCtx sends a HEAD request bound to ctx. There is never a response body on
+// HEAD so only the status code (and error) is returned.
+func (h *httpClient) HeadCtx(ctx context.Context, urll string, errorResponse interface{}) (StatusCode, error) {
+	return h.DoCtx(ctx, http.MethodHead, urll, nil, nil, errorResponse)
+}
+
+// Options is OptionsCtx with context.Background()
+func (h *httpClient) Options(urll string, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.OptionsCtx(context.Background(), urll, result, errorResponse)
+}
+
+// OptionsCtx sends an OPTIONS request bound to ctx.
+func (h *httpClient) OptionsCtx(ctx context.Context, urll string, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.DoCtx(ctx, http.MethodOptions, urll, nil, result, errorResponse)
+}
+
+// Do is DoCtx with context.Background()
+func (h *httpClient) Do(method, urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	return h.DoCtx(context.Background(), method, urll, body, result, errorResponse)
+}
+
+// DoCtx sends a request with any method. Body (if not nil) is encoded based on
+// the Content-Type header, on status < 300 the response is decoded into result
+// and on status >= 400 into errorResponse (if the server replied with json).
+func (h *httpClient) DoCtx(ctx context.Context, method, urll string, body interface{}, result interface{}, errorResponse interface{}) (StatusCode, error) {
+	response, err := h.DoResponse(ctx, method, urll, body, result, errorResponse)
+	if response == nil {
+		return StatusCode(0), err
 	}
-	request.Header = h.headers
-	if h.useBasicAuth {
-		request.SetBasicAuth(h.basicAuthData.Username, h.basicAuthData.Password)
+	return response.StatusCode, err
+}
+
+// DoResponse is DoCtx returning the rich Response (headers, raw error body).
+// Response is nil when the request could not be sent or the body not decoded.
+func (h *httpClient) DoResponse(ctx context.Context, method, urll string, body interface{}, result interface{}, errorResponse interface{}) (*Response, error) {
+	p, err := h.encodeBody(ctx, body)
+	if err != nil {
+		return nil, err
 	}
-	response, err := h.client.Do(request)
+	p, encoding, err := h.compressionConfig().compressPayload(p)
 	if err != nil {
-		return StatusCode(0), err
+		return nil, err
 	}
-	if result != nil && response.StatusCode < 300 {
-		err = json.NewDecoder(response.Body).Decode(&result)
-		if err != nil {
-			return StatusCode(0), err
+	request, err := h.newRequest(ctx, method, urll, p.reader)
+	if err != nil {
+		// streamed body (multipart) is already writing into the pipe
+		if closer, ok := p.reader.(io.Closer); ok {
+			closer.Close()
 		}
-		return StatusCode(response.StatusCode), nil
+		return nil, err
 	}
-	// jika response code tidak sama dengan 200
-	// maka dilakukan pengecekan errornya dan akan di return errorr messagennya
-	if response.StatusCode >= 400 {
-		//  Jika status errornya bukan berbentuk json
-		if response.Header.Get("Content-Type") != "application/json" {
-			bodyByte, err := ioutil.ReadAll(response.Body)
-			if err != nil {
-				return StatusCode(0), err
-			}
-			return StatusCode(response.StatusCode), errors.New(string(bodyByte))
-		} else {
-			//  jika status errornya merupakan json
-			//  maka akan di decode hasil error codenya
-			if errorResponse != nil {
-				err = json.NewDecoder(response.Body).Decode(&errorResponse)
-				if err != nil {
-					return StatusCode(0), err
-				}
-			}
-			return StatusCode(response.StatusCode), errors.New("error response")
-		}
+	if p.contentType != "" {
+		request.Header.Set("Content-Type", p.contentType)
+	}
+	if p.getBody != nil {
+		request.GetBody = p.getBody
+	}
+	if encoding != "" {
+		request.Header.Set("Content-Encoding", encoding)
 	}
-	response.Body.Close()
-	return StatusCode(response.StatusCode), nil
+	response, cacheHit, err := h.sendCached(request)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer drainBody(response)
+	resp, err := h.decodeResponse(ctx, response, result, errorResponse)
+	if resp != nil {
+		resp.CacheHit = cacheHit
+	}
+	return resp, err
+}
+
+func (h *httpClient) compressionConfig() *Compression {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.compression
 }
 
 // PostStream sends a POST request and returns the raw *http.Response.
 // The caller is responsible for reading and closing the response body.
 // This is useful for SSE (Server-Sent Events) and streaming APIs.
 func (h *httpClient) PostStream(url string, data any) (*http.Response, error) {
-	var body io.Reader
+	return h.PostStreamCtx(context.Background(), url, data)
+}
+
+// PostStreamCtx is PostStream bound to ctx. Cancelling ctx also aborts reading
+// the returned response body.
+func (h *httpClient) PostStreamCtx(ctx context.Context, url string, data any) (*http.Response, error) {
+	var p payload
 	if data != nil {
 		jsonData, err := json.Marshal(data)
 		if err != nil {
 			return nil, err
 		}
-		body = bytes.NewReader(jsonData)
+		p.reader = bytes.NewReader(jsonData)
 	}
-
-	req, err := http.NewRequest("POST", url, body)
+	p, encoding, err := h.compressionConfig().compressPayload(p)
 	if err != nil {
 		return nil, err
 	}
 
-	// Apply stored headers
-	req.Header = h.headers
-
-	// Apply query params if any
-	if len(h.params) > 0 {
-		q := req.URL.Query()
-		for key, value := range h.params {
-			q.Add(key, value)
-		}
-		req.URL.RawQuery = q.Encode()
+	req, err := h.newRequest(ctx, "POST", url, p.reader)
+	if err != nil {
+		return nil, err
 	}
-
-	// Apply basic auth if set
-	if h.useBasicAuth {
-		req.SetBasicAuth(h.basicAuthData.Username, h.basicAuthData.Password)
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
 	}
-
-	return h.client.Do(req)
+	return h.send(req)
 }
 
 // GetStream sends a GET request and returns the raw *http.Response.
 // The caller is responsible for reading and closing the response body.
 // This is useful for SSE (Server-Sent Events) and streaming APIs.
 func (h *httpClient) GetStream(url string) (*http.Response, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	return h.GetStreamCtx(context.Background(), url)
+}
+
+// GetStreamCtx is GetStream bound to ctx. Cancelling ctx also aborts reading
+// the returned response body.
+func (h *httpClient) GetStreamCtx(ctx context.Context, url string) (*http.Response, error) {
+	req, err := h.newRequest(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	return h.send(req)
+}
 
+// newRequest builds the request and applies the stored headers, query params
+// and basic auth. Headers are copied so the request never writes back into the
+// client's header map. A request from the builder brings its own copy of the
+// client headers and query (taken in R()) and they are used instead.
+func (h *httpClient) newRequest(ctx context.Context, method, urll string, body io.Reader) (*http.Request, error) {
+	request, err := http.NewRequestWithContext(ctx, method, urll, body)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	defaults, fromBuilder := ctx.Value(requestDefaultsKey{}).(requestDefaults)
 	// Apply stored headers
-	req.Header = h.headers
+	if fromBuilder {
+		request.Header = defaults.header.Clone()
+	} else {
+		request.Header = http.Header(h.headers).Clone()
+	}
+	if request.Header == nil {
+		request.Header = http.Header{}
+	}
+	// Headers for this call only (ie: Last-Event-ID for SSE reconnect)
+	if extra, ok := ctx.Value(requestHeadersKey{}).(http.Header); ok {
+		for key, values := range extra {
+			request.Header[key] = append([]string(nil), values...)
+		}
+	}
 
-	// Apply query params if any
-	if len(h.params) > 0 {
-		q := req.URL.Query()
+	// Apply query params if any, merged with the query already in the url
+	if fromBuilder && len(defaults.query) > 0 {
+		q := request.URL.Query()
+		// may have several values per key
+		for key, values := range defaults.query {
+			q[key] = append([]string(nil), values...)
+		}
+		request.URL.RawQuery = q.Encode()
+	} else if !fromBuilder && len(h.params) > 0 {
+		q := request.URL.Query()
 		for key, value := range h.params {
-			q.Add(key, value)
+			q.Set(key, value)
 		}
-		req.URL.RawQuery = q.Encode()
+		request.URL.RawQuery = q.Encode()
 	}
 
 	// Apply basic auth if set
 	if h.useBasicAuth {
-		req.SetBasicAuth(h.basicAuthData.Username, h.basicAuthData.Password)
+		request.SetBasicAuth(h.basicAuthData.Username, h.basicAuthData.Password)
 	}
+	return request, nil
+}
+
+type requestHeadersKey struct{}
 
-	return h.client.Do(req)
+// withRequestHeaders attaches headers that newRequest sets on top of the client
+// headers, for helpers that need per call headers without touching the client
+func withRequestHeaders(ctx context.Context, header http.Header) context.Context {
+	return context.WithValue(ctx, requestHeadersKey{}, header)
 }
 
-func (h *httpClient) Get(urll string, result interface{}, errorResponse interface{}) (StatusCode, error) {
-	baseUrl := urll
-	request, err := http.NewRequest("GET", baseUrl, nil)
-	if err != nil {
-		return StatusCode(0), err
-	}
-	if h.params != nil {
-		// Jika paramsnya tidak nil maka akan di set query paramnnya sesuai datanya
-		params := url.Values{}
-		for k, v := range h.params {
-			params.Set(k, v)
+type requestDefaultsKey struct{}
+
+// requestDefaults are the headers and query of the request builder, they
+// replace the client ones in newRequest
+type requestDefaults struct {
+	header http.Header
+	query  url.Values
+}
+
+func withRequestDefaults(ctx context.Context, header http.Header, query url.Values) context.Context {
+	return context.WithValue(ctx, requestDefaultsKey{}, requestDefaults{header: header, query: query})
+}
+
+// contentType is the Content-Type set for this call, or the client one
+func (h *httpClient) contentType(ctx context.Context) []string {
+	if extra, ok := ctx.Value(requestHeadersKey{}).(http.Header); ok {
+		if values, ok := extra["Content-Type"]; ok {
+			return values
 		}
-		request.URL.RawQuery = params.Encode()
 	}
-	// set header
-	request.Header = h.headers
-	if h.useBasicAuth {
-		request.SetBasicAuth(h.basicAuthData.Username, h.basicAuthData.Password)
+	if defaults, ok := ctx.Value(requestDefaultsKey{}).(requestDefaults); ok {
+		return defaults.header["Content-Type"]
 	}
-	response, err := h.client.Do(request)
-	if err != nil {
-		return StatusCode(0), err
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.headers["Content-Type"]
+}
+
+// payload is the encoded request body
+type payload struct {
+	reader      io.Reader
+	contentType string                        // overrides the Content-Type header when not empty
+	getBody     func() (io.ReadCloser, error) // replays a streamed body on retry, bytes bodies do not need it
+}
+
+// encodeBody picks the body encoding from the Content-Type header. Body without
+// a json or url-encoded Content-Type is not sent at all (same as it always was).
+// Bodies created with JSONBody and *Multipart bring their own encoding, their
+// content type then overrides the header.
+func (h *httpClient) encodeBody(ctx context.Context, body interface{}) (payload, error) {
+	if body == nil {
+		return payload{}, nil
 	}
-	defer response.Body.Close()
-	if result != nil && response.StatusCode < 300 {
-		err = json.NewDecoder(response.Body).Decode(&result)
+	switch b := body.(type) {
+	case jsonBody:
+		_body, err := h.marshalPayload(b.value)
+		if err != nil {
+			return payload{}, err
+		}
+		return payload{reader: bytes.NewReader(_body), contentType: MIME_JSON}, nil
+	case *Multipart:
+		return b.payload(), nil
+	}
+	contentType := h.contentType(ctx)
+	// jika content typenya merupakan application json
+	// maka akan di encode menjadi string menggunakan jsonENcode
+	// if funk.ContainsString(h.headers["Content-Type"], "application/json") {
+	if object.ArrayAContainsBString(contentType, MIME_JSON) {
+		_body, err := h.marshalPayload(body)
 		if err != nil {
-			return StatusCode(0), err
+			return payload{}, err
 		}
+		return payload{reader: bytes.NewReader(_body)}, nil
+		// } else if funk.ContainsString(h.headers["Content-Type"], "application/x-www-form-urlencoded") {
+	} else if object.ArrayAContainsBString(contentType, MIME_FORM) {
+		//  Jika body requestnya merupakan url-encoded
+		// data akan di set pada url values lalau di encode menajdi string
+		data := formValues(body)
+		return payload{reader: strings.NewReader(data.Encode())}, nil
 	}
+	return payload{}, nil
+}
+
+// decodeResponse decodes the success body into result, or the error body into
+// errorResponse. An empty success body (204, HEAD) is not an error. The error
+// body is kept raw in Response.Body and status >= 400 returns *HTTPError.
+func (h *httpClient) decodeResponse(ctx context.Context, response *http.Response, result interface{}, errorResponse interface{}) (*Response, error) {
+	resp := newResponse(response)
+	limit := h.responseLimit(response.StatusCode)
+	if result != nil && response.StatusCode < 300 {
+		if limit >= 0 && response.ContentLength > limit {
+			return nil, newBodyTooLargeError(response, limit)
+		}
+		body := newLimitedBody(response.Body, limit, newBodyTooLargeError(response, limit))
+		err := json.NewDecoder(body).Decode(&result)
+		if err != nil && err != io.EOF {
+			return nil, contextError(ctx, err)
+		}
+		return resp, nil
+	}
+	// jika response code tidak sama dengan 200
+	// maka dilakukan pengecekan errornya dan akan di return errorr messagennya
 	if response.StatusCode >= 400 {
-		if response.Header.Get("Content-Type") != "application/json" {
-			//  Jika reponse errornya bukan merupakan application/json
-			// maka akan menggunakan response bodynya
-			bodyByte, err := ioutil.ReadAll(response.Body)
-			if err != nil {
-				return StatusCode(0), err
-			}
-			return StatusCode(response.StatusCode), errors.New(string(bodyByte))
+		bodyByte, truncated, err := readLimited(response.Body, limit)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		resp.Body = bodyByte
+		//  Jika status errornya bukan berbentuk json
+		//  (body yang terpotong juga tidak bisa di decode)
+		if !isJSONContentType(response.Header.Get("Content-Type")) || truncated {
+			httpErr := newHTTPError(resp, nil)
+			httpErr.Truncated = httpErr.Truncated || truncated
+			return resp, httpErr
 		} else {
 			//  jika status errornya merupakan json
 			//  maka akan di decode hasil error codenya
+			//  body yang tidak cocok dengan errorResponse tetap *HTTPError,
+			//  supaya status code selalu bisa dibaca
 			if errorResponse != nil {
-				err = json.NewDecoder(response.Body).Decode(&errorResponse)
+				err := json.Unmarshal(bodyByte, &errorResponse)
 				if err != nil {
-					return StatusCode(0), err
+					httpErr := newHTTPError(resp, nil)
+					httpErr.DecodeErr = err
+					return resp, httpErr
 				}
 			}
-			return StatusCode(response.StatusCode), errors.New("error response")
+			return resp, newHTTPError(resp, errorResponse)
 		}
 	}
+	return resp, nil
+}
 
-	return StatusCode(response.StatusCode), nil
+// contextError prefers the context error over the transport/decoder error, so
+// callers can check errors.Is(err, context.DeadlineExceeded) reliably.
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
 }
 
 func (h *httpClient) marshalPayload(p interface{}) ([]byte, error) {
@@ -262,21 +496,38 @@ func (h *httpClient) marshalPayload(p interface{}) ([]byte, error) {
 	}
 	return data, nil
 }
+
+// SetHeader replaces the client headers with a copy of headers, later changes
+// to the map do not leak into the client. Use R() for per call headers.
 func (h *httpClient) SetHeader(headers map[string][]string) *httpClient {
 	//  untuk reset value headernya
-	h.headers = map[string][]string{}
-	h.headers = headers
+	copied := make(map[string][]string, len(headers))
+	for key, values := range headers {
+		copied[key] = append([]string(nil), values...)
+	}
+	h.mu.Lock()
+	h.headers = copied
+	h.mu.Unlock()
 	return h
 }
 
+// SetQueryParams replaces the client query params with a copy of params. Use
+// R() for per call (and multi value) query params.
 func (h *httpClient) SetQueryParams(params map[string]string) *httpClient {
 	// untuk reset value paramsnnya
-	h.params = map[string]string{}
-	h.params = params
+	copied := make(map[string]string, len(params))
+	for key, value := range params {
+		copied[key] = value
+	}
+	h.mu.Lock()
+	h.params = copied
+	h.mu.Unlock()
 	return h
 }
 func (h *httpClient) SetBasicAuth(username, password string) *httpClient {
 	// untuk reset value paramsnnya
+	h.mu.Lock()
+	defer h.mu.Unlock()
 	h.basicAuthData.Username = username
 	h.basicAuthData.Password = password
 	h.useBasicAuth = true
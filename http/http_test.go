@@ -0,0 +1,141 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetCtxDecodes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"name":"meda"}`))
+	}))
+	defer srv.Close()
+
+	var out struct {
+		Name string `json:"name"`
+	}
+	code, err := NewHttp().GetCtx(context.Background(), srv.URL, &out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != http.StatusOK || out.Name != "meda" {
+		t.Fatalf("got code=%d name=%q", code, out.Name)
+	}
+}
+
+func TestGetCtxDeadline(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NewHttp().GetCtx(ctx, srv.URL, nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v, want deadline exceeded", err)
+	}
+}
+
+func TestPostStreamCtxCancelBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("data: first\n\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resp, err := NewHttp().PostStreamCtx(ctx, srv.URL, map[string]string{"q": "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	buf := make([]byte, 64)
+	if _, err := resp.Body.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for {
+		if _, err = resp.Body.Read(buf); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("read after cancel = %v, want context.Canceled", err)
+	}
+}
+
+func TestVerbsShareBodyEncoding(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"method": r.Method,
+			"body":   string(body),
+			"ctype":  r.Header.Get("Content-Type"),
+		})
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetHeader(map[string][]string{"Content-Type": {"application/x-www-form-urlencoded"}})
+	payload := struct {
+		Name string `json:"name"`
+	}{Name: "meda"}
+
+	for _, method := range []string{http.MethodPut, http.MethodPatch, "PURGE"} {
+		var out map[string]string
+		code, err := c.Do(method, srv.URL, payload, &out, nil)
+		if err != nil || code != http.StatusOK {
+			t.Fatalf("%s: code=%d err=%v", method, code, err)
+		}
+		if out["method"] != method || out["body"] != "name=meda" {
+			t.Fatalf("%s: got %v", method, out)
+		}
+	}
+
+	var out map[string]string
+	if code, err := c.Delete(srv.URL, &out, nil); err != nil || code != http.StatusNoContent {
+		t.Fatalf("Delete: code=%d err=%v", code, err)
+	}
+	if code, err := c.Head(srv.URL, nil); err != nil || code != http.StatusNoContent {
+		t.Fatalf("Head: code=%d err=%v", code, err)
+	}
+	if _, err := c.Options(srv.URL, &out, nil); err != nil || out["method"] != http.MethodOptions {
+		t.Fatalf("Options: %v %v", out, err)
+	}
+}
+
+func TestErrorResponseDecoded(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(`{"message":"exists"}`))
+	}))
+	defer srv.Close()
+
+	var errResp struct {
+		Message string `json:"message"`
+	}
+	code, err := NewHttp().Put(srv.URL, nil, nil, &errResp)
+	if err == nil || code != http.StatusConflict || errResp.Message != "exists" {
+		t.Fatalf("code=%d err=%v errResp=%+v", code, err, errResp)
+	}
+}
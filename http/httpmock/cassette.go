@@ -0,0 +1,285 @@
+package httpmock
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+
+	httpclient "github.com/medatechnology/goutil/http"
+)
+
+// Cassette recording: run the test once against the real service in record
+// mode, the interactions are saved to a json file that is committed with the
+// test. Later runs replay the file without network.
+// Usage:
+//
+//	rec, err := httpmock.NewRecorder("testdata/partner_api.json", httpmock.ModeReplayOrRecord, nil)
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	c := rec.Client()
+//
+// Replay matches method, url and body, each recorded interaction is used once
+// in order, then reused when the same request comes again. The headers in
+// FilterHeaders (Authorization, Cookie, ...) are never written to the file, the
+// query params in FilterQuery (access_token, ...) are written as FILTERED and
+// replay matches the url filtered the same way.
+
+type Mode int
+
+const (
+	ModeReplay         Mode = iota // only the file, an unknown request fails with ErrNoInteraction
+	ModeRecord                     // always call the real transport and (re)write the file
+	ModeReplayOrRecord             // replay when the file exists, record otherwise
+)
+
+var ErrNoInteraction = errors.New("httpmock: no recorded interaction matches the request")
+
+var DefaultFilterHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"}
+
+var DefaultFilterQuery = []string{"access_token", "api_key", "apikey", "client_secret", "token"}
+
+const FILTERED_VALUE = "FILTERED"
+
+type RecordedRequest struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 bool        `json:"body_base64,omitempty"` // body is not utf-8 text
+}
+
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+	BodyBase64 bool        `json:"body_base64,omitempty"`
+}
+
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Recorder struct {
+	Path          string
+	FilterHeaders []string
+	FilterQuery   []string
+	mode          Mode
+	recording     bool
+	real          http.RoundTripper
+	mu            sync.Mutex
+	cassette      Cassette
+	used          []bool
+}
+
+// NewRecorder loads the cassette at path (ModeReplay needs it to exist). real is
+// the transport used when recording, nil uses http.DefaultTransport.
+func NewRecorder(path string, mode Mode, real http.RoundTripper) (*Recorder, error) {
+	if real == nil {
+		real = http.DefaultTransport
+	}
+	r := &Recorder{
+		Path:          path,
+		FilterHeaders: DefaultFilterHeaders,
+		FilterQuery:   DefaultFilterQuery,
+		mode:          mode,
+		real:          real,
+	}
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil && mode != ModeRecord:
+		if err := json.Unmarshal(data, &r.cassette); err != nil {
+			return nil, fmt.Errorf("httpmock: cassette %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.cassette.Interactions))
+	case errors.Is(err, os.ErrNotExist) && mode == ModeReplay:
+		return nil, fmt.Errorf("httpmock: cassette %s: %w", path, err)
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	default:
+		r.recording = true
+	}
+	return r, nil
+}
+
+// Recording is true when requests go to the real transport
+func (r *Recorder) Recording() bool {
+	return r.recording
+}
+
+// Client returns a httpclient that sends every request through the recorder
+func (r *Recorder) Client(opts ...httpclient.Option) httpclient.HttpClient {
+	return httpclient.NewHttp(append(opts, httpclient.WithTransport(r))...)
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	if !r.recording {
+		return r.replay(req, body)
+	}
+	resp, err := r.real.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	interaction := Interaction{
+		Request:  RecordedRequest{Method: req.Method, URL: r.filterURL(req.URL), Header: r.filter(req.Header)},
+		Response: RecordedResponse{StatusCode: resp.StatusCode, Header: r.filter(resp.Header)},
+	}
+	interaction.Request.Body, interaction.Request.BodyBase64 = encodeBody(body)
+	interaction.Response.Body, interaction.Response.BodyBase64 = encodeBody(respBody)
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.mu.Unlock()
+	if err := r.Save(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Save writes the cassette file, RoundTrip saves after every recorded request.
+// The lock is held until the file is in place so a concurrent Save cannot
+// leave an older snapshot on disk. The file is written to a temp file and
+// renamed, a reader never sees half a cassette.
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(r.Path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, filepath.Base(r.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), r.Path)
+}
+
+func (r *Recorder) replay(req *http.Request, body []byte) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	found := -1
+	for i, interaction := range r.cassette.Interactions {
+		if !interaction.Request.matches(req.Method, r.filterURL(req.URL), body) {
+			continue
+		}
+		if !r.used[i] {
+			found = i
+			break
+		}
+		if found < 0 {
+			found = i
+		}
+	}
+	if found < 0 {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, req.URL)
+	}
+	r.used[found] = true
+	recorded := r.cassette.Interactions[found].Response
+	respBody, err := decodeBody(recorded.Body, recorded.BodyBase64)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode:    recorded.StatusCode,
+		Status:        fmt.Sprintf("%d %s", recorded.StatusCode, http.StatusText(recorded.StatusCode)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        recorded.Header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
+
+func (r *Recorder) filter(header http.Header) http.Header {
+	filtered := header.Clone()
+	for _, name := range r.FilterHeaders {
+		filtered.Del(name)
+	}
+	return filtered
+}
+
+// filterURL is the url with the FilterQuery params set to FILTERED_VALUE, the
+// url is returned unchanged when it has none of them
+func (r *Recorder) filterURL(u *url.URL) string {
+	query := u.Query()
+	filtered := false
+	for _, name := range r.FilterQuery {
+		if values, ok := query[name]; ok {
+			for i := range values {
+				values[i] = FILTERED_VALUE
+			}
+			filtered = true
+		}
+	}
+	if !filtered {
+		return u.String()
+	}
+	copied := *u
+	copied.RawQuery = query.Encode()
+	return copied.String()
+}
+
+func (rr RecordedRequest) matches(method, urll string, body []byte) bool {
+	if rr.Method != method || rr.URL != urll {
+		return false
+	}
+	recorded, err := decodeBody(rr.Body, rr.BodyBase64)
+	return err == nil && bytes.Equal(recorded, body)
+}
+
+func encodeBody(body []byte) (string, bool) {
+	if utf8.Valid(body) {
+		return string(body), false
+	}
+	return base64.StdEncoding.EncodeToString(body), true
+}
+
+func decodeBody(body string, isBase64 bool) ([]byte, error) {
+	if isBase64 {
+		return base64.StdEncoding.DecodeString(body)
+	}
+	return []byte(body), nil
+}
@@ -0,0 +1,405 @@
+package httpmock
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	httpclient "github.com/medatechnology/goutil/http"
+)
+
+// Test double for code that uses httpclient.HttpClient. Mock is a
+// http.RoundTripper (no network at all) and a http.Handler (in-process server).
+// Usage:
+//
+//	m := httpmock.New()
+//	m.On("GET", "/users/{id}").Header("Authorization", "Bearer t").
+//		ReplyJSON(200, User{ID: 1})
+//	m.On("POST", "/users").JSONBody(map[string]any{"name": "bob"}).
+//		ReplyJSON(201, User{ID: 2}).Times(1)
+//
+//	c := m.Client() // httpclient.NewHttp(httpclient.WithTransport(m))
+//	svc := NewService(c)
+//	...
+//	m.AssertCalled(t, "POST", "/users", 1)
+//	m.AssertExpectations(t) // every route was called
+//
+//	srv := m.Server() // or a real listener, for code that only takes a url
+//	defer srv.Close()
+//
+// Routes are matched in the order they were added. A request no route matches
+// fails with ErrNoRoute (501 from the server).
+
+var ErrNoRoute = errors.New("httpmock: no route matches the request")
+
+// Call is a request received by the mock
+type Call struct {
+	Method string
+	URL    string
+	Path   string
+	Header http.Header
+	Body   []byte
+}
+
+type Mock struct {
+	mu     sync.Mutex
+	routes []*Route
+	calls  []Call
+}
+
+func New() *Mock {
+	return &Mock{}
+}
+
+// On adds a route. path is the url path, a {name} segment matches any single
+// segment and a trailing /* matches the rest of the path.
+func (m *Mock) On(method, path string) *Route {
+	r := &Route{method: strings.ToUpper(method), path: path, status: http.StatusOK, header: http.Header{}}
+	m.mu.Lock()
+	m.routes = append(m.routes, r)
+	m.mu.Unlock()
+	return r
+}
+
+// Client returns a httpclient that sends every request to the mock
+func (m *Mock) Client(opts ...httpclient.Option) httpclient.HttpClient {
+	return httpclient.NewHttp(append(opts, httpclient.WithTransport(m))...)
+}
+
+// Server starts a httptest.Server serving the routes, close it when done
+func (m *Mock) Server() *httptest.Server {
+	return httptest.NewServer(m)
+}
+
+// RoundTrip implements http.RoundTripper
+func (m *Mock) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	route := m.match(req, body)
+	if route == nil {
+		return nil, fmt.Errorf("%w: %s %s", ErrNoRoute, req.Method, req.URL)
+	}
+	if route.replyFunc != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		return route.replyFunc(req)
+	}
+	if route.err != nil {
+		return nil, route.err
+	}
+	return &http.Response{
+		StatusCode:    route.status,
+		Status:        fmt.Sprintf("%d %s", route.status, http.StatusText(route.status)),
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        route.header.Clone(),
+		Body:          io.NopCloser(bytes.NewReader(route.body)),
+		ContentLength: int64(len(route.body)),
+		Request:       req,
+	}, nil
+}
+
+// ServeHTTP implements http.Handler
+func (m *Mock) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	resp, err := m.RoundTrip(req)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, ErrNoRoute) {
+			status = http.StatusNotImplemented
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer resp.Body.Close()
+	for key, values := range resp.Header {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func (m *Mock) match(req *http.Request, body []byte) *Route {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, Call{
+		Method: req.Method,
+		URL:    req.URL.String(),
+		Path:   req.URL.Path,
+		Header: req.Header.Clone(),
+		Body:   body,
+	})
+	for _, r := range m.routes {
+		if r.times > 0 && r.calls >= r.times {
+			continue
+		}
+		if r.matches(req, body) {
+			r.calls++
+			return r
+		}
+	}
+	return nil
+}
+
+// Calls returns every request received, matched or not
+func (m *Mock) Calls() []Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Call(nil), m.calls...)
+}
+
+// CallCount counts the received requests with method and path (same pattern
+// rules as On)
+func (m *Mock) CallCount(method, path string) int {
+	count := 0
+	for _, call := range m.Calls() {
+		if strings.EqualFold(call.Method, method) && matchPath(path, call.Path) {
+			count++
+		}
+	}
+	return count
+}
+
+// AssertCalled fails the test when method and path were not called times
+// times, times < 0 means at least once
+func (m *Mock) AssertCalled(t testing.TB, method, path string, times int) {
+	t.Helper()
+	count := m.CallCount(method, path)
+	if times < 0 && count == 0 {
+		t.Errorf("httpmock: %s %s was not called", method, path)
+	} else if times >= 0 && count != times {
+		t.Errorf("httpmock: %s %s called %d times, want %d", method, path, count, times)
+	}
+}
+
+// AssertNotCalled fails the test when method and path were called
+func (m *Mock) AssertNotCalled(t testing.TB, method, path string) {
+	t.Helper()
+	m.AssertCalled(t, method, path, 0)
+}
+
+// AssertExpectations fails the test for every route that was never matched, or
+// matched less than its Times
+func (m *Mock) AssertExpectations(t testing.TB) {
+	t.Helper()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, r := range m.routes {
+		if r.calls == 0 || (r.times > 0 && r.calls < r.times) {
+			t.Errorf("httpmock: route %s %s matched %d times", r.method, r.path, r.calls)
+		}
+	}
+}
+
+// Reset removes all routes and recorded calls
+func (m *Mock) Reset() {
+	m.mu.Lock()
+	m.routes = nil
+	m.calls = nil
+	m.mu.Unlock()
+}
+
+// Route is one expected request and its canned response
+type Route struct {
+	method    string
+	path      string
+	query     map[string]string
+	headers   map[string]string
+	jsonBody  interface{}
+	hasJSON   bool
+	matchers  []func(*http.Request, []byte) bool
+	times     int
+	calls     int
+	status    int
+	header    http.Header
+	body      []byte
+	err       error
+	replyFunc func(*http.Request) (*http.Response, error)
+}
+
+// Query requires the query param to have value (among its values)
+func (r *Route) Query(key, value string) *Route {
+	if r.query == nil {
+		r.query = make(map[string]string)
+	}
+	r.query[key] = value
+	return r
+}
+
+// Header requires the request header to have value (among its values)
+func (r *Route) Header(key, value string) *Route {
+	if r.headers == nil {
+		r.headers = make(map[string]string)
+	}
+	r.headers[key] = value
+	return r
+}
+
+// JSONBody requires the body to be json equal to v (key order and spacing
+// do not matter)
+func (r *Route) JSONBody(v interface{}) *Route {
+	r.jsonBody = v
+	r.hasJSON = true
+	return r
+}
+
+// Match adds a custom matcher, body is the full request body
+func (r *Route) Match(matcher func(req *http.Request, body []byte) bool) *Route {
+	r.matchers = append(r.matchers, matcher)
+	return r
+}
+
+// Times lets the route match only n times, later requests go to the next routes
+func (r *Route) Times(n int) *Route {
+	r.times = n
+	return r
+}
+
+// Reply sets the status code of the response
+func (r *Route) Reply(status int) *Route {
+	r.status = status
+	return r
+}
+
+// ReplyHeader adds a response header
+func (r *Route) ReplyHeader(key, value string) *Route {
+	r.header.Add(key, value)
+	return r
+}
+
+// ReplyString replies with a text body
+func (r *Route) ReplyString(status int, body string) *Route {
+	r.status = status
+	r.body = []byte(body)
+	if r.header.Get("Content-Type") == "" {
+		r.header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	return r
+}
+
+// ReplyJSON replies with v encoded as json, panics when v cannot be encoded
+// (a broken test fixture)
+func (r *Route) ReplyJSON(status int, v interface{}) *Route {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic("httpmock: ReplyJSON: " + err.Error())
+	}
+	r.status = status
+	r.body = body
+	r.header.Set("Content-Type", httpclient.MIME_JSON)
+	return r
+}
+
+// ReplyError fails the round trip with err, like a network error
+func (r *Route) ReplyError(err error) *Route {
+	r.err = err
+	return r
+}
+
+// ReplyFunc builds the response for every matched request
+func (r *Route) ReplyFunc(reply func(req *http.Request) (*http.Response, error)) *Route {
+	r.replyFunc = reply
+	return r
+}
+
+func (r *Route) matches(req *http.Request, body []byte) bool {
+	if r.method != "" && r.method != req.Method {
+		return false
+	}
+	if !matchPath(r.path, req.URL.Path) {
+		return false
+	}
+	query := req.URL.Query()
+	for key, value := range r.query {
+		if !contains(query[key], value) {
+			return false
+		}
+	}
+	for key, value := range r.headers {
+		if !contains(req.Header.Values(key), value) {
+			return false
+		}
+	}
+	if r.hasJSON && !jsonEqual(r.jsonBody, body) {
+		return false
+	}
+	for _, matcher := range r.matchers {
+		if !matcher(req, body) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchPath matches {name} segments and a trailing /*
+func matchPath(pattern, path string) bool {
+	if pattern == "" || pattern == "*" {
+		return true
+	}
+	patternParts := strings.Split(strings.Trim(pattern, "/"), "/")
+	pathParts := strings.Split(strings.Trim(path, "/"), "/")
+	for i, part := range patternParts {
+		if part == "*" && i == len(patternParts)-1 {
+			return len(pathParts) >= i
+		}
+		if i >= len(pathParts) {
+			return false
+		}
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			continue
+		}
+		if part != pathParts[i] {
+			return false
+		}
+	}
+	return len(patternParts) == len(pathParts)
+}
+
+func jsonEqual(want interface{}, body []byte) bool {
+	var got interface{}
+	if err := json.Unmarshal(body, &got); err != nil {
+		return false
+	}
+	// round trip want so structs compare like the decoded body
+	wantJSON, err := json.Marshal(want)
+	if err != nil {
+		return false
+	}
+	var wantValue interface{}
+	if err := json.Unmarshal(wantJSON, &wantValue); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(wantValue, got)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// readBody reads the request body and puts a reader over the same bytes back
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
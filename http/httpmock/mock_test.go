@@ -0,0 +1,183 @@
+package httpmock
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	httpclient "github.com/medatechnology/goutil/http"
+)
+
+type user struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestMockRoutes(t *testing.T) {
+	m := New()
+	m.On("GET", "/users/{id}").Header("Authorization", "Bearer t").Query("expand", "roles").
+		ReplyJSON(200, user{ID: 7, Name: "alice"})
+	m.On("POST", "/users").JSONBody(map[string]any{"name": "bob", "id": 0}).
+		ReplyJSON(201, user{ID: 8, Name: "bob"}).Times(1)
+	m.On("POST", "/users").ReplyString(409, "duplicate")
+
+	c := m.Client()
+	c.SetHeader(map[string][]string{"Authorization": {"Bearer t"}, "Content-Type": {httpclient.MIME_JSON}})
+
+	var got user
+	if _, err := c.Get("http://api.test/users/7?expand=roles", &got, nil); err != nil || got.Name != "alice" {
+		t.Fatalf("get: %+v, %v", got, err)
+	}
+	code, err := c.Post("http://api.test/users", user{Name: "bob"}, &got, nil)
+	if err != nil || code != 201 || got.ID != 8 {
+		t.Fatalf("post: %d %+v %v", code, got, err)
+	}
+	// Times(1) is used up, the fallback route answers
+	code, err = c.Post("http://api.test/users", user{Name: "bob"}, &got, nil)
+	if code != 409 || !errors.Is(err, httpclient.ErrClientError) {
+		t.Fatalf("second post: %d %v", code, err)
+	}
+	// query matcher does not match
+	if _, err := c.Get("http://api.test/users/7", nil, nil); !errors.Is(err, ErrNoRoute) {
+		t.Fatalf("err = %v, want ErrNoRoute", err)
+	}
+
+	m.AssertCalled(t, "GET", "/users/{id}", 2)
+	m.AssertCalled(t, "POST", "/users", 2)
+	m.AssertNotCalled(t, "DELETE", "/users/{id}")
+	m.AssertExpectations(t)
+	if calls := m.Calls(); len(calls) != 4 || string(calls[1].Body) != `{"id":0,"name":"bob"}` {
+		t.Fatalf("calls = %+v", calls)
+	}
+}
+
+func TestMockServer(t *testing.T) {
+	m := New()
+	m.On("GET", "/health").ReplyHeader("X-Env", "test").Reply(204)
+	m.On("GET", "/boom").ReplyError(errors.New("connection reset"))
+	srv := m.Server()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/health")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 204 || resp.Header.Get("X-Env") != "test" {
+		t.Fatalf("resp = %d %v", resp.StatusCode, resp.Header)
+	}
+	for path, want := range map[string]int{"/missing": 501, "/boom": 502} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: status = %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/users", "/users", true},
+		{"/users", "/users/1", false},
+		{"/users/{id}", "/users/1", true},
+		{"/users/{id}/roles", "/users/1/roles", true},
+		{"/files/*", "/files/a/b/c", true},
+		{"/files/*", "/other/a", false},
+		{"", "/anything", true},
+	}
+	for _, tc := range cases {
+		if got := matchPath(tc.pattern, tc.path); got != tc.want {
+			t.Errorf("matchPath(%q, %q) = %v", tc.pattern, tc.path, got)
+		}
+	}
+}
+
+func TestRecorderRecordThenReplay(t *testing.T) {
+	real := New()
+	real.On("POST", "/echo").ReplyJSON(200, user{ID: 1, Name: "recorded"})
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	rec, err := NewRecorder(path, ModeReplayOrRecord, real)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Recording() {
+		t.Fatal("missing cassette must record")
+	}
+	c := rec.Client()
+	c.SetHeader(map[string][]string{"Content-Type": {httpclient.MIME_JSON}, "Authorization": {"Bearer secret"}})
+	var got user
+	if _, err := c.Post("http://api.test/echo", map[string]string{"q": "1"}, &got, nil); err != nil || got.Name != "recorded" {
+		t.Fatalf("record: %+v %v", got, err)
+	}
+
+	replay, err := NewRecorder(path, ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replay.cassette.Interactions) != 1 || replay.cassette.Interactions[0].Request.Header.Get("Authorization") != "" {
+		t.Fatalf("cassette = %+v", replay.cassette)
+	}
+	c = replay.Client()
+	c.SetHeader(map[string][]string{"Content-Type": {httpclient.MIME_JSON}})
+	for i := 0; i < 2; i++ {
+		got = user{}
+		if _, err := c.Post("http://api.test/echo", map[string]string{"q": "1"}, &got, nil); err != nil || got.Name != "recorded" {
+			t.Fatalf("replay %d: %+v %v", i, got, err)
+		}
+	}
+	if _, err := c.Post("http://api.test/echo", map[string]string{"q": "2"}, nil, nil); !errors.Is(err, ErrNoInteraction) {
+		t.Fatalf("err = %v, want ErrNoInteraction", err)
+	}
+	real.AssertCalled(t, "POST", "/echo", 1)
+
+	if _, err := NewRecorder(filepath.Join(t.TempDir(), "none.json"), ModeReplay, nil); err == nil {
+		t.Fatal("replay without cassette must fail")
+	}
+}
+
+func TestRecorderFiltersQuery(t *testing.T) {
+	real := New()
+	real.On("GET", "/items").ReplyJSON(200, user{ID: 2, Name: "items"})
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	rec, _ := NewRecorder(path, ModeRecord, real)
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec.Client().Get("http://api.test/items?access_token=secret&page=1", nil, nil)
+		}()
+	}
+	wg.Wait()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Fatalf("token written to the cassette: %s", data)
+	}
+	replay, err := NewRecorder(path, ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(replay.cassette.Interactions); n != 5 {
+		t.Fatalf("interactions on disk = %d, want 5", n)
+	}
+	var got user
+	if _, err := replay.Client().Get("http://api.test/items?page=1&access_token=other", &got, nil); err != nil || got.Name != "items" {
+		t.Fatalf("replay: %+v %v", got, err)
+	}
+}
@@ -0,0 +1,114 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"iter"
+	"net/http"
+)
+
+// NDJSON / JSON Lines streaming decoder.
+// Usage:
+//
+//	for row, err := range httpclient.GetJSONLines[Row](ctx, c, url, 0) {
+//		if err != nil {
+//			return err
+//		}
+//		process(row)
+//	}
+//
+//	// or with a response you opened yourself
+//	resp, _ := c.PostStreamCtx(ctx, url, query)
+//	for row, err := range httpclient.StreamJSONLines[Row](resp, 0) { ... }
+//
+// The body is closed when the loop ends (break included). A line that is not
+// valid json yields an error but the loop may continue with the next line,
+// read errors (cancelled context, dropped connection) end the loop.
+
+const (
+	DEFAULT_JSONL_MAX_LINE_SIZE = 16 << 20 // 16MB
+	jsonlInitialBuffer          = 64 << 10
+)
+
+var ErrJSONLineTooLarge = errors.New("json line exceeds max line size")
+
+// StreamJSONLines decodes every non empty line of the body into T.
+// maxLineSize 0 uses DEFAULT_JSONL_MAX_LINE_SIZE.
+func StreamJSONLines[T any](resp *http.Response, maxLineSize int) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		defer resp.Body.Close()
+		if maxLineSize <= 0 {
+			maxLineSize = DEFAULT_JSONL_MAX_LINE_SIZE
+		}
+		ctx := context.Background()
+		if resp.Request != nil {
+			ctx = resp.Request.Context()
+		}
+		scanner := bufio.NewScanner(resp.Body)
+		scanner.Buffer(make([]byte, 0, min(jsonlInitialBuffer, maxLineSize)), maxLineSize)
+		line := 0
+		for scanner.Scan() {
+			line++
+			data := bytes.TrimSpace(scanner.Bytes())
+			if len(data) == 0 {
+				continue
+			}
+			var value T
+			if err := json.Unmarshal(data, &value); err != nil {
+				if !yield(value, fmt.Errorf("json line %d: %w", line, err)) {
+					return
+				}
+				continue
+			}
+			if !yield(value, nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			if errors.Is(err, bufio.ErrTooLong) {
+				err = ErrJSONLineTooLarge
+			}
+			var zero T
+			yield(zero, contextError(ctx, err))
+		}
+	}
+}
+
+// GetJSONLines opens the stream with GET and decodes every line into T. A non
+// 2xx response yields a single *HTTPError.
+func GetJSONLines[T any](ctx context.Context, c HttpClient, urll string, maxLineSize int) iter.Seq2[T, error] {
+	return openJSONLines[T](maxLineSize, func() (*http.Response, error) {
+		return c.GetStreamCtx(ctx, urll)
+	})
+}
+
+// PostJSONLines opens the stream with POST (body as json) and decodes every line into T
+func PostJSONLines[T any](ctx context.Context, c HttpClient, urll string, body any, maxLineSize int) iter.Seq2[T, error] {
+	return openJSONLines[T](maxLineSize, func() (*http.Response, error) {
+		return c.PostStreamCtx(ctx, urll, body)
+	})
+}
+
+func openJSONLines[T any](maxLineSize int, open func() (*http.Response, error)) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		resp, err := open()
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			yield(zero, streamStatusError(resp))
+			return
+		}
+		for value, err := range StreamJSONLines[T](resp, maxLineSize) {
+			if !yield(value, err) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testRow struct {
+	N int `json:"n"`
+}
+
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestStreamJSONLines(t *testing.T) {
+	body := &closeTracker{Reader: strings.NewReader("{\"n\":1}\r\n\n{\"n\":2}\nnot-json\n{\"n\":3}")}
+	var got []int
+	var errs int
+	for row, err := range StreamJSONLines[testRow](&http.Response{Body: body}, 0) {
+		if err != nil {
+			errs++
+			continue
+		}
+		got = append(got, row.N)
+	}
+	if fmt.Sprint(got) != "[1 2 3]" || errs != 1 {
+		t.Fatalf("rows = %v errs = %d", got, errs)
+	}
+	if !body.closed {
+		t.Fatal("body not closed")
+	}
+
+	body = &closeTracker{Reader: strings.NewReader("{\"n\":1}\n{\"n\":2}\n")}
+	for range StreamJSONLines[testRow](&http.Response{Body: body}, 0) {
+		break
+	}
+	if !body.closed {
+		t.Fatal("body not closed on break")
+	}
+
+	long := `{"n":1,"pad":"` + strings.Repeat("x", 200) + `"}`
+	var lastErr error
+	for _, err := range StreamJSONLines[testRow](&http.Response{Body: io.NopCloser(strings.NewReader(long))}, 100) {
+		lastErr = err
+	}
+	if !errors.Is(lastErr, ErrJSONLineTooLarge) {
+		t.Fatalf("err = %v, want ErrJSONLineTooLarge", lastErr)
+	}
+}
+
+func TestGetJSONLinesCancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintln(w, `{"n":1}`)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var lastErr error
+	for row, err := range GetJSONLines[testRow](ctx, NewHttp(), srv.URL, 0) {
+		if err != nil {
+			lastErr = err
+			break
+		}
+		if row.N == 1 {
+			cancel()
+		}
+	}
+	if !errors.Is(lastErr, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", lastErr)
+	}
+
+	for _, err := range GetJSONLines[testRow](context.Background(), NewHttp(), srv.URL+"/missing", 0) {
+		lastErr = err
+	}
+	if !errors.Is(lastErr, ErrNotFound) {
+		t.Fatalf("err = %v, want ErrNotFound", lastErr)
+	}
+}
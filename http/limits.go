@@ -0,0 +1,140 @@
+package httpclient
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Response body size limits. A success body bigger than the max response size
+// fails the call with *BodyTooLargeError (errors.Is(err, ErrBodyTooLarge)), an
+// error body (status >= 400) is cut at the max error body size and returned in
+// *HTTPError with Truncated set, the errorResponse is then not decoded.
+// Usage:
+//
+//	c := httpclient.NewHttp()
+//	c.SetMaxResponseSize(8 << 20)  // 8MB, -1 means no limit
+//	c.SetMaxErrorBodySize(64 << 10) // 64KB
+//
+//	_, err := c.Get(url, &result, nil)
+//	var tooLarge *httpclient.BodyTooLargeError
+//	if errors.As(err, &tooLarge) { ... tooLarge.Limit ... }
+//
+// The limits apply to the verb methods and DoResponse, the stream methods hand
+// out the raw body. Bodies are always drained (up to DRAIN_BODY_LIMIT) and
+// closed so the connection goes back to the pool.
+
+const (
+	DEFAULT_MAX_RESPONSE_SIZE   = 64 << 20  // 64MB
+	DEFAULT_MAX_ERROR_BODY_SIZE = 1 << 20   // 1MB
+	DRAIN_BODY_LIMIT            = 256 << 10 // bigger leftovers close the connection instead of reading it all
+)
+
+var ErrBodyTooLarge = errors.New("response body too large")
+
+type BodyTooLargeError struct {
+	Limit         int64
+	ContentLength int64 // from the response header, -1 when unknown
+	StatusCode    StatusCode
+	Method        string
+	URL           string
+}
+
+func (e *BodyTooLargeError) Error() string {
+	if e.ContentLength >= 0 {
+		return fmt.Sprintf("%s %s: response body of %d bytes exceeds limit of %d bytes", e.Method, e.URL, e.ContentLength, e.Limit)
+	}
+	return fmt.Sprintf("%s %s: response body exceeds limit of %d bytes", e.Method, e.URL, e.Limit)
+}
+
+func (e *BodyTooLargeError) Unwrap() error {
+	return ErrBodyTooLarge
+}
+
+// SetMaxResponseSize limits success bodies, 0 uses DEFAULT_MAX_RESPONSE_SIZE and
+// a negative size means no limit
+func (h *httpClient) SetMaxResponseSize(size int64) *httpClient {
+	h.mu.Lock()
+	h.maxResponseSize = size
+	h.mu.Unlock()
+	return h
+}
+
+// SetMaxErrorBodySize limits error bodies (status >= 400), 0 uses
+// DEFAULT_MAX_ERROR_BODY_SIZE and a negative size means no limit
+func (h *httpClient) SetMaxErrorBodySize(size int64) *httpClient {
+	h.mu.Lock()
+	h.maxErrorBodySize = size
+	h.mu.Unlock()
+	return h
+}
+
+func (h *httpClient) responseLimit(statusCode int) int64 {
+	h.mu.RLock()
+	limit, fallback := h.maxResponseSize, int64(DEFAULT_MAX_RESPONSE_SIZE)
+	if statusCode >= 400 {
+		limit, fallback = h.maxErrorBodySize, DEFAULT_MAX_ERROR_BODY_SIZE
+	}
+	h.mu.RUnlock()
+	if limit == 0 {
+		return fallback
+	}
+	return limit
+}
+
+func newBodyTooLargeError(response *http.Response, limit int64) *BodyTooLargeError {
+	resp := newResponse(response)
+	return &BodyTooLargeError{
+		Limit:         limit,
+		ContentLength: response.ContentLength,
+		StatusCode:    resp.StatusCode,
+		Method:        resp.Method,
+		URL:           resp.URL,
+	}
+}
+
+// limitedBody fails with err once more than limit bytes are read, the bytes up
+// to the limit are still returned
+type limitedBody struct {
+	r     io.Reader
+	limit int64
+	read  int64
+	err   error
+}
+
+func newLimitedBody(r io.Reader, limit int64, err error) io.Reader {
+	if limit < 0 {
+		return r
+	}
+	return &limitedBody{r: r, limit: limit, err: err}
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.read > l.limit {
+		return 0, l.err
+	}
+	// one byte over the limit is enough to know the body is too large
+	if max := l.limit - l.read + 1; int64(len(p)) > max {
+		p = p[:max]
+	}
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+	if l.read > l.limit {
+		return n - int(l.read-l.limit), l.err
+	}
+	return n, err
+}
+
+// readLimited reads the body up to limit bytes, truncated is true when there was more
+func readLimited(r io.Reader, limit int64) (body []byte, truncated bool, err error) {
+	if limit < 0 {
+		body, err = io.ReadAll(r)
+		return body, false, err
+	}
+	body, err = io.ReadAll(io.LimitReader(r, limit+1))
+	if int64(len(body)) > limit {
+		return body[:limit], true, err
+	}
+	return body, false, err
+}
@@ -0,0 +1,106 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMaxResponseSize(t *testing.T) {
+	big := `{"name":"` + strings.Repeat("x", 2000) + `"}`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MIME_JSON)
+		if r.URL.Query().Get("chunked") != "" {
+			// no Content-Length, the limit is hit while reading
+			w.(http.Flusher).Flush()
+		}
+		w.Write([]byte(big))
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetMaxResponseSize(1000)
+	for _, url := range []string{srv.URL, srv.URL + "?chunked=1"} {
+		var got testUser
+		_, err := c.Get(url, &got, nil)
+		var tooLarge *BodyTooLargeError
+		if !errors.Is(err, ErrBodyTooLarge) || !errors.As(err, &tooLarge) || tooLarge.Limit != 1000 {
+			t.Fatalf("%s: err = %v, want BodyTooLargeError", url, err)
+		}
+	}
+
+	c.SetMaxResponseSize(-1)
+	var got testUser
+	if _, err := c.Get(srv.URL, &got, nil); err != nil || len(got.Name) != 2000 {
+		t.Fatalf("no limit: err = %v, name len %d", err, len(got.Name))
+	}
+}
+
+func TestMaxErrorBodySize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MIME_JSON)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(`{"error":"` + strings.Repeat("e", 500) + `"}`))
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetMaxErrorBodySize(100)
+	var errBody map[string]string
+	resp, err := c.DoResponse(context.Background(), http.MethodGet, srv.URL, nil, nil, &errBody)
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) || !errors.Is(err, ErrServerError) {
+		t.Fatalf("err = %v, want HTTPError 502", err)
+	}
+	if !httpErr.Truncated || len(resp.Body) != 100 || errBody != nil {
+		t.Fatalf("truncated = %v, body len = %d, decoded = %v", httpErr.Truncated, len(resp.Body), errBody)
+	}
+}
+
+func TestBodiesDrainedForReuse(t *testing.T) {
+	var conns int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MIME_JSON)
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+		// trailing data after the json value must not keep the connection busy
+		w.Write([]byte(`{"id":1}` + strings.Repeat(" ", 4096) + "\n"))
+	}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	c := NewHttp()
+	for i := 0; i < 5; i++ {
+		var got testUser
+		c.Get(srv.URL, &got, nil)
+		c.Get(srv.URL+"/error", nil, nil)
+		c.Head(srv.URL, nil)
+	}
+	if n := atomic.LoadInt32(&conns); n != 1 {
+		t.Fatalf("connections = %d, want 1 reused connection", n)
+	}
+}
+
+func TestLimitedBody(t *testing.T) {
+	errTooLarge := errors.New("too large")
+	body := newLimitedBody(strings.NewReader("0123456789"), 4, errTooLarge)
+	buf := make([]byte, 16)
+	n, err := body.Read(buf)
+	if n != 4 || err != errTooLarge || string(buf[:n]) != "0123" {
+		t.Fatalf("n = %d err = %v data = %q", n, err, buf[:n])
+	}
+	if n, err := body.Read(buf); n != 0 || err != errTooLarge {
+		t.Fatalf("second read n = %d err = %v", n, err)
+	}
+}
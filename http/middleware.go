@@ -0,0 +1,70 @@
+package httpclient
+
+import "net/http"
+
+// Middleware (interceptor) chain, http.RoundTripper style. A middleware gets the
+// next RoundTripper and returns a new one, so it can change the request before
+// calling next and inspect (or replace) the response after.
+// Usage:
+//
+//	c := httpclient.NewHttp()
+//	c.Use(func(next http.RoundTripper) http.RoundTripper {
+//		return httpclient.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+//			start := time.Now()
+//			resp, err := next.RoundTrip(req)
+//			simplelog.LogFormat("%s %s took %s", req.Method, req.URL, time.Since(start))
+//			return resp, err
+//		})
+//	})
+//
+// Middlewares run in the order they are added (the first one is the outermost),
+// for every attempt of every verb and stream method, after the headers, query
+// params and basic auth of the client are applied to the request.
+
+type Middleware func(next http.RoundTripper) http.RoundTripper
+
+// RoundTripperFunc adapts a function into http.RoundTripper
+type RoundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f RoundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Use appends middlewares to the chain
+func (h *httpClient) Use(middleware ...Middleware) *httpClient {
+	h.mu.Lock()
+	h.middlewares = append(h.middlewares, middleware...)
+	h.mu.Unlock()
+	return h
+}
+
+// roundTripper builds the chain with the http.Client (and the compression
+// transport, if set) at the end and the instrumentation, if set, in front
+func (h *httpClient) roundTripper() http.RoundTripper {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var rt http.RoundTripper = RoundTripperFunc(h.client.Do)
+	if h.compression != nil {
+		rt = h.compression.transport(rt)
+	}
+	for i := len(h.middlewares) - 1; i >= 0; i-- {
+		rt = h.middlewares[i](rt)
+	}
+	if h.instrumentation != nil {
+		rt = h.instrumentation.transport(rt)
+	}
+	return rt
+}
+
+// CorrelationIDMiddleware sets header (ie: X-Correlation-ID) to newID() when the
+// request does not have it yet, so all attempts of one call share the same id.
+func CorrelationIDMiddleware(header string, newID func() string) Middleware {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if req.Header.Get(header) == "" {
+				req.Header.Set(header, newID())
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package httpclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMiddlewareOrderAndStreams(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Seen", strings.Join(r.Header.Values("X-Trace"), ","))
+		w.Header().Set("X-Correlation-ID", r.Header.Get("X-Correlation-ID"))
+	}))
+	defer srv.Close()
+
+	var order []string
+	trace := func(name string) Middleware {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				order = append(order, name+">")
+				req.Header.Add("X-Trace", name)
+				resp, err := next.RoundTrip(req)
+				order = append(order, "<"+name)
+				return resp, err
+			})
+		}
+	}
+
+	c := NewHttp()
+	c.Use(trace("a"), trace("b"))
+	c.Use(CorrelationIDMiddleware("X-Correlation-ID", func() string { return "cid-1" }))
+
+	resp, err := c.GetStream(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Seen"); got != "a,b" {
+		t.Fatalf("server saw X-Trace %q, want a,b", got)
+	}
+	if got := resp.Header.Get("X-Correlation-ID"); got != "cid-1" {
+		t.Fatalf("correlation id = %q", got)
+	}
+	if got := strings.Join(order, " "); got != "a> b> <b <a" {
+		t.Fatalf("order = %s", got)
+	}
+
+	order = nil
+	if _, err := c.Delete(srv.URL, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(order) != 4 {
+		t.Fatalf("verb methods must run the chain too, got %v", order)
+	}
+}
@@ -0,0 +1,183 @@
+package httpclient
+
+import (
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Multipart is a multipart/form-data body builder, pass it as body to Post/Put/
+// Patch/Do. The body is streamed through a pipe while the request is sent so
+// files are never fully loaded in memory.
+// Usage:
+//
+//	body := httpclient.NewMultipart().
+//		Field("title", "invoice").
+//		Fields(meta).                                  // struct or map, like form encoding
+//		FilePath("document", "/tmp/invoice.pdf", "application/pdf").
+//		File("thumbnail", "thumb.png", pngReader, "image/png")
+//	code, err := c.Post(url, body, &result, nil)
+//
+// Files added with FilePath are opened again for every attempt, so the request
+// can be retried. A body with File (io.Reader) can only be sent once and is never
+// retried.
+
+const (
+	DEFAULT_MULTIPART_FILE_TYPE = "application/octet-stream"
+)
+
+type multipartPart struct {
+	fieldName   string
+	fileName    string
+	contentType string
+	value       string                    // plain field value when open is nil
+	open        func() (io.Reader, error) // file content
+	close       func(io.Reader)           // called after the content is copied
+}
+
+type Multipart struct {
+	parts      []multipartPart
+	boundary   string
+	replayable bool
+}
+
+func NewMultipart() *Multipart {
+	return &Multipart{
+		boundary:   multipart.NewWriter(io.Discard).Boundary(),
+		replayable: true,
+	}
+}
+
+// Field adds a plain form field
+func (m *Multipart) Field(name, value string) *Multipart {
+	m.parts = append(m.parts, multipartPart{fieldName: name, value: value})
+	return m
+}
+
+// Fields adds every field of a struct (json/db tags) or map, with the same
+// conversion as x-www-form-urlencoded bodies (numbers, bools, times, slices)
+func (m *Multipart) Fields(v interface{}) *Multipart {
+	values := formValues(v)
+	for _, key := range sortedKeys(values) {
+		for _, value := range values[key] {
+			m.Field(key, value)
+		}
+	}
+	return m
+}
+
+// File adds a file read from r. contentType empty uses application/octet-stream.
+// The reader is consumed once, the request with this body is not retried.
+func (m *Multipart) File(fieldName, fileName string, r io.Reader, contentType string) *Multipart {
+	used := false
+	m.replayable = false
+	m.parts = append(m.parts, multipartPart{
+		fieldName:   fieldName,
+		fileName:    fileName,
+		contentType: contentType,
+		open: func() (io.Reader, error) {
+			if used {
+				return nil, io.ErrUnexpectedEOF
+			}
+			used = true
+			return r, nil
+		},
+	})
+	return m
+}
+
+// FilePath adds a file from disk, the file name sent is the base name of path.
+// The file is opened while sending, a missing file fails the request.
+func (m *Multipart) FilePath(fieldName, path string, contentType string) *Multipart {
+	m.parts = append(m.parts, multipartPart{
+		fieldName:   fieldName,
+		fileName:    filepath.Base(path),
+		contentType: contentType,
+		open: func() (io.Reader, error) {
+			return os.Open(path)
+		},
+		close: func(r io.Reader) {
+			r.(*os.File).Close()
+		},
+	})
+	return m
+}
+
+// ContentType returns multipart/form-data with the boundary
+func (m *Multipart) ContentType() string {
+	return "multipart/form-data; boundary=" + m.boundary
+}
+
+// Reader starts streaming the body, write errors (ie: missing file) are
+// returned by Read so the request fails with that error.
+func (m *Multipart) Reader() io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(m.writeTo(pw))
+	}()
+	return pr
+}
+
+func (m *Multipart) writeTo(w io.Writer) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(m.boundary); err != nil {
+		return err
+	}
+	for _, part := range m.parts {
+		if part.open == nil {
+			if err := mw.WriteField(part.fieldName, part.value); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := m.writeFile(mw, part); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+func (m *Multipart) writeFile(mw *multipart.Writer, part multipartPart) error {
+	content, err := part.open()
+	if err != nil {
+		return err
+	}
+	if part.close != nil {
+		defer part.close(content)
+	}
+	contentType := part.contentType
+	if contentType == "" {
+		contentType = DEFAULT_MULTIPART_FILE_TYPE
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", `form-data; name="`+escapeQuotes(part.fieldName)+
+		`"; filename="`+escapeQuotes(part.fileName)+`"`)
+	header.Set("Content-Type", contentType)
+	pw, err := mw.CreatePart(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(pw, content)
+	return err
+}
+
+// payload returns the encoded body, replayable only if there is no io.Reader file
+func (m *Multipart) payload() payload {
+	p := payload{reader: m.Reader(), contentType: m.ContentType()}
+	if m.replayable {
+		p.getBody = func() (io.ReadCloser, error) {
+			return m.Reader(), nil
+		}
+	}
+	return p
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+// same as the unexported one in mime/multipart
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,115 @@
+package httpclient
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMultipartUploadAndRetry(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "invoice.pdf")
+	if err := os.WriteFile(path, []byte("%PDF-fake"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parse: %v", err)
+			return
+		}
+		if r.URL.Path == "/reader" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			atomic.AddInt32(&calls, 1)
+			return
+		}
+		if r.FormValue("title") != "invoice" || r.FormValue("pages") != "3" || r.FormValue("draft") != "true" {
+			t.Errorf("fields = %v", r.MultipartForm.Value)
+		}
+		f, hdr, err := r.FormFile("document")
+		if err != nil {
+			t.Errorf("file: %v", err)
+			return
+		}
+		content, _ := io.ReadAll(f)
+		if hdr.Filename != "invoice.pdf" || hdr.Header.Get("Content-Type") != "application/pdf" || string(content) != "%PDF-fake" {
+			t.Errorf("file %s %s %q", hdr.Filename, hdr.Header.Get("Content-Type"), content)
+		}
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	policy := fastRetryPolicy()
+	policy.RetryNonIdempotent = true
+	c := NewHttp()
+	c.SetRetryPolicy(policy)
+
+	body := NewMultipart().
+		Field("title", "invoice").
+		Fields(map[string]interface{}{"pages": 3, "draft": true}).
+		FilePath("document", path, "application/pdf")
+	code, err := c.Post(srv.URL, body, nil, nil)
+	if err != nil || code != http.StatusOK || calls != 2 {
+		t.Fatalf("code=%d err=%v calls=%d", code, err, calls)
+	}
+
+	// io.Reader file cannot be replayed, so no retry
+	calls = 0
+	body = NewMultipart().File("document", "a.txt", strings.NewReader("x"), "")
+	code, _ = c.Post(srv.URL+"/reader", body, nil, nil)
+	if code != http.StatusServiceUnavailable || calls != 1 {
+		t.Fatalf("code=%d calls=%d, want single attempt", code, calls)
+	}
+}
+
+func TestMultipartMissingFile(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+
+	body := NewMultipart().FilePath("document", "/does/not/exist", "")
+	if _, err := NewHttp().Post(srv.URL, body, nil, nil); err == nil {
+		t.Fatal("missing file must fail the request")
+	}
+}
+
+func TestFormValues(t *testing.T) {
+	at := time.Date(2025, 4, 16, 10, 0, 0, 0, time.UTC)
+	type form struct {
+		Name   string    `json:"name"`
+		Age    int       `json:"age"`
+		Score  float64   `json:"score"`
+		Active bool      `json:"active"`
+		Since  time.Time `json:"since"`
+		Tags   []string  `json:"tags"`
+		IDs    []int     `json:"ids"`
+		Ptr    *int      `json:"ptr"`
+	}
+	seven := 7
+	got := formValues(form{Name: "meda", Age: 30, Score: 1.5, Active: true, Since: at,
+		Tags: []string{"a", "b"}, IDs: []int{1, 2}, Ptr: &seven})
+	want := url.Values{
+		"name":   {"meda"},
+		"age":    {"30"},
+		"score":  {"1.5"},
+		"active": {"true"},
+		"since":  {"2025-04-16T10:00:00Z"},
+		"tags":   {"a", "b"},
+		"ids":    {"1", "2"},
+		"ptr":    {"7"},
+	}
+	if got.Encode() != want.Encode() {
+		t.Fatalf("formValues = %s\nwant %s", got.Encode(), want.Encode())
+	}
+}
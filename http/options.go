@@ -0,0 +1,194 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Functional options for NewHttp. Without options NewHttp behaves like it always
+// did: a plain &http.Client{} with no timeout.
+// Usage:
+//
+//	caPool, err := httpclient.LoadCertPool("/etc/ssl/internal-ca.pem")
+//	cert, err := tls.LoadX509KeyPair("client.crt", "client.key")
+//	c := httpclient.NewHttp(
+//		httpclient.WithTimeout(10*time.Second),
+//		httpclient.WithDialTimeout(2*time.Second),
+//		httpclient.WithMaxIdleConnsPerHost(32),
+//		httpclient.WithRootCAs(caPool),
+//		httpclient.WithClientCertificates(cert),
+//	)
+//
+// Transport tuning options (dial/TLS/header timeouts, idle conns, HTTP/2, proxy,
+// CA and client certificates) are applied on a clone of the transport, they are
+// ignored when WithTransport sets a RoundTripper that is not *http.Transport.
+
+const (
+	DEFAULT_DIAL_KEEP_ALIVE = 30 * time.Second
+)
+
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	client                *http.Client
+	transport             http.RoundTripper
+	timeout               time.Duration
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	maxIdleConnsPerHost   int
+	http2                 *bool
+	proxy                 *url.URL
+	rootCAs               *x509.CertPool
+	certificates          []tls.Certificate
+}
+
+// WithTimeout sets the overall timeout of each request (http.Client.Timeout),
+// including reading the body. Do not use it for long streams, use a context.
+func WithTimeout(d time.Duration) Option {
+	return func(o *clientOptions) { o.timeout = d }
+}
+
+// WithDialTimeout limits how long establishing the tcp connection may take
+func WithDialTimeout(d time.Duration) Option {
+	return func(o *clientOptions) { o.dialTimeout = d }
+}
+
+// WithTLSHandshakeTimeout limits how long the TLS handshake may take
+func WithTLSHandshakeTimeout(d time.Duration) Option {
+	return func(o *clientOptions) { o.tlsHandshakeTimeout = d }
+}
+
+// WithResponseHeaderTimeout limits the wait for the response headers after the
+// request is fully written
+func WithResponseHeaderTimeout(d time.Duration) Option {
+	return func(o *clientOptions) { o.responseHeaderTimeout = d }
+}
+
+// WithMaxIdleConnsPerHost sets how many keep-alive connections are kept per host
+func WithMaxIdleConnsPerHost(n int) Option {
+	return func(o *clientOptions) { o.maxIdleConnsPerHost = n }
+}
+
+// WithHTTP2 enables (default) or disables HTTP/2 negotiation
+func WithHTTP2(enabled bool) Option {
+	return func(o *clientOptions) { o.http2 = &enabled }
+}
+
+// WithProxy sends all requests through the proxy, nil keeps the environment
+// proxy (HTTP_PROXY, HTTPS_PROXY, NO_PROXY)
+func WithProxy(proxy *url.URL) Option {
+	return func(o *clientOptions) { o.proxy = proxy }
+}
+
+// WithRootCAs replaces the system CA bundle for verifying servers
+func WithRootCAs(pool *x509.CertPool) Option {
+	return func(o *clientOptions) { o.rootCAs = pool }
+}
+
+// WithClientCertificates presents the certificates to the server (mTLS)
+func WithClientCertificates(certs ...tls.Certificate) Option {
+	return func(o *clientOptions) { o.certificates = append(o.certificates, certs...) }
+}
+
+// WithHTTPClient uses the given client (copied, so the original is not modified)
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *clientOptions) { o.client = client }
+}
+
+// WithTransport uses the given RoundTripper as the transport
+func WithTransport(rt http.RoundTripper) Option {
+	return func(o *clientOptions) { o.transport = rt }
+}
+
+// LoadCertPool reads PEM encoded CA certificates from files into a pool
+func LoadCertPool(pemFiles ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	for _, file := range pemFiles {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in " + file)
+		}
+	}
+	return pool, nil
+}
+
+func (o *clientOptions) tunesTransport() bool {
+	return o.dialTimeout > 0 || o.tlsHandshakeTimeout > 0 || o.responseHeaderTimeout > 0 ||
+		o.maxIdleConnsPerHost > 0 || o.http2 != nil || o.proxy != nil ||
+		o.rootCAs != nil || len(o.certificates) > 0
+}
+
+// build creates the http.Client from the options
+func (o *clientOptions) build() *http.Client {
+	client := &http.Client{}
+	if o.client != nil {
+		copied := *o.client
+		client = &copied
+	}
+	if o.transport != nil {
+		client.Transport = o.transport
+	}
+	if o.tunesTransport() {
+		base, ok := client.Transport.(*http.Transport)
+		if client.Transport == nil {
+			base, ok = http.DefaultTransport.(*http.Transport)
+		}
+		if ok {
+			client.Transport = o.tune(base.Clone())
+		}
+	}
+	if o.timeout > 0 {
+		client.Timeout = o.timeout
+	}
+	return client
+}
+
+func (o *clientOptions) tune(t *http.Transport) *http.Transport {
+	if o.dialTimeout > 0 {
+		t.DialContext = (&net.Dialer{
+			Timeout:   o.dialTimeout,
+			KeepAlive: DEFAULT_DIAL_KEEP_ALIVE,
+		}).DialContext
+	}
+	if o.tlsHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = o.tlsHandshakeTimeout
+	}
+	if o.responseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = o.responseHeaderTimeout
+	}
+	if o.maxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = o.maxIdleConnsPerHost
+	}
+	if o.http2 != nil {
+		t.ForceAttemptHTTP2 = *o.http2
+		if !*o.http2 {
+			// non-nil empty map is how net/http disables HTTP/2
+			t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		}
+	}
+	if o.proxy != nil {
+		t.Proxy = http.ProxyURL(o.proxy)
+	}
+	if o.rootCAs != nil || len(o.certificates) > 0 {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		if o.rootCAs != nil {
+			t.TLSClientConfig.RootCAs = o.rootCAs
+		}
+		if len(o.certificates) > 0 {
+			t.TLSClientConfig.Certificates = o.certificates
+		}
+	}
+	return t
+}
@@ -0,0 +1,67 @@
+package httpclient
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOptionsRootCAs(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Proto", r.Proto)
+	}))
+	defer srv.Close()
+
+	if _, err := NewHttp().Get(srv.URL, nil, nil); err == nil {
+		t.Fatal("self-signed server must fail without the CA")
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	c := NewHttp(WithRootCAs(pool), WithTLSHandshakeTimeout(time.Second), WithHTTP2(false))
+	resp, err := c.GetStream(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get("X-Proto") != "HTTP/1.1" {
+		t.Fatalf("proto = %s, want HTTP/1.1", resp.Header.Get("X-Proto"))
+	}
+}
+
+func TestOptionsTimeout(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	start := time.Now()
+	_, err := NewHttp(WithTimeout(50*time.Millisecond)).Get(srv.URL, nil, nil)
+	if err == nil || time.Since(start) > time.Second {
+		t.Fatalf("err=%v after %s, want timeout", err, time.Since(start))
+	}
+}
+
+func TestOptionsDoNotModifyInjectedClient(t *testing.T) {
+	own := &http.Client{Transport: &http.Transport{}}
+	c := NewHttp(WithHTTPClient(own), WithTimeout(time.Second), WithMaxIdleConnsPerHost(7)).(*httpClient)
+	if own.Timeout != 0 || own.Transport.(*http.Transport).MaxIdleConnsPerHost != 0 {
+		t.Fatal("injected client was modified")
+	}
+	if c.client.Timeout != time.Second || c.client.Transport.(*http.Transport).MaxIdleConnsPerHost != 7 {
+		t.Fatal("options not applied on the copy")
+	}
+
+	rt := RoundTripperFunc(http.DefaultTransport.RoundTrip)
+	c = NewHttp(WithTransport(rt), WithDialTimeout(time.Second)).(*httpClient)
+	if _, ok := c.client.Transport.(RoundTripperFunc); !ok {
+		t.Fatal("custom RoundTripper must be kept as is")
+	}
+}
@@ -0,0 +1,264 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/medatechnology/goutil/medaerror"
+)
+
+// Token bucket rate limiter for outgoing calls, per client and/or per host.
+// Usage:
+//
+//	c := httpclient.NewHttp()
+//	c.SetRateLimiter(httpclient.NewRateLimiter(50, 10))                     // all calls: 50/s, burst 10
+//	c.SetHostRateLimiter("api.partner.com", httpclient.NewRateLimiter(5, 1)) // this host: 5/s
+//	c.SetHostRateLimiter("slow.partner.com", httpclient.NewRateLimiter(1, 1).SetFailFast(true))
+//
+// Calls wait for a token. When the wait would go past the context deadline, or
+// the limiter is fail fast, the call fails right away with a
+// medaerror.MedaError (Code = RATE_LIMITED_ERROR) that matches ErrRateLimited.
+// Host limiters also follow the server: Retry-After on 429/503 and
+// X-RateLimit-Remaining: 0 with X-RateLimit-Reset pause the bucket until then.
+// The client limiter only counts its own calls, since the headers of one host
+// say nothing about the others.
+
+const (
+	RATE_LIMITED_ERROR = 1429 // medaerror code returned when the limiter rejects a call
+
+	HEADER_RATELIMIT_REMAINING = "X-RateLimit-Remaining"
+	HEADER_RATELIMIT_RESET     = "X-RateLimit-Reset"
+)
+
+var ErrRateLimited = errors.New("rate limited")
+
+type RateLimiter struct {
+	rate         float64 // tokens per second, <= 0 means only the server headers limit
+	burst        float64
+	failFast     bool
+	mu           sync.Mutex
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+	now          func() time.Time
+}
+
+// NewRateLimiter allows ratePerSecond calls on average with bursts of burst
+// calls (burst < 1 is set to 1). The bucket starts full.
+func NewRateLimiter(ratePerSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   ratePerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// SetFailFast makes Wait return the rate limited error instead of waiting
+func (l *RateLimiter) SetFailFast(failFast bool) *RateLimiter {
+	l.mu.Lock()
+	l.failFast = failFast
+	l.mu.Unlock()
+	return l
+}
+
+// Allow takes a token if one is available now, without waiting
+func (l *RateLimiter) Allow() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.refill(now)
+	if now.Before(l.blockedUntil) || (l.rate > 0 && l.tokens < 1) {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// Wait takes a token, waiting until one is available
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.mu.Lock()
+	now := l.now()
+	l.refill(now)
+	var wait time.Duration
+	if now.Before(l.blockedUntil) {
+		wait = l.blockedUntil.Sub(now)
+	}
+	if l.rate > 0 {
+		// take the token now, a negative balance is the wait for it
+		l.tokens--
+		if l.tokens < 0 {
+			if tokenWait := time.Duration(-l.tokens / l.rate * float64(time.Second)); tokenWait > wait {
+				wait = tokenWait
+			}
+		}
+	}
+	if wait > 0 {
+		deadline, hasDeadline := ctx.Deadline()
+		if l.failFast || (hasDeadline && now.Add(wait).After(deadline)) {
+			l.giveBack()
+			l.mu.Unlock()
+			return rateLimitedError(wait)
+		}
+	}
+	l.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.giveBack()
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// Observe adapts the bucket to the rate limit headers of the response
+func (l *RateLimiter) Observe(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.refill(now)
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now); ok {
+			l.blockUntil(now.Add(wait))
+			return
+		}
+	}
+	remaining, err := strconv.Atoi(resp.Header.Get(HEADER_RATELIMIT_REMAINING))
+	if err != nil {
+		return
+	}
+	if remaining <= 0 {
+		if reset, ok := parseRateLimitReset(resp.Header.Get(HEADER_RATELIMIT_RESET), now); ok {
+			l.blockUntil(reset)
+		}
+		l.tokens = math.Min(l.tokens, 0)
+		return
+	}
+	// the server knows better how many calls are left
+	if float64(remaining) < l.tokens {
+		l.tokens = float64(remaining)
+	}
+}
+
+func (l *RateLimiter) refill(now time.Time) {
+	if !l.last.IsZero() && l.rate > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+}
+
+// release gives back a token taken by Wait or Allow for a call that was not sent
+func (l *RateLimiter) release() {
+	l.mu.Lock()
+	l.giveBack()
+	l.mu.Unlock()
+}
+
+func (l *RateLimiter) giveBack() {
+	if l.rate > 0 {
+		l.tokens = math.Min(l.burst, l.tokens+1)
+	}
+}
+
+func (l *RateLimiter) blockUntil(at time.Time) {
+	if at.After(l.blockedUntil) {
+		l.blockedUntil = at
+	}
+	l.tokens = math.Min(l.tokens, 0)
+}
+
+// parseRateLimitReset accepts unix epoch seconds or seconds from now, both are
+// used in the wild
+func parseRateLimitReset(value string, now time.Time) (time.Time, bool) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return time.Time{}, false
+	}
+	if n > 1_000_000_000 {
+		return time.Unix(n, 0), true
+	}
+	return now.Add(time.Duration(n) * time.Second), true
+}
+
+func rateLimitedError(wait time.Duration) error {
+	err := medaerror.NewMedaErr(RATE_LIMITED_ERROR,
+		"rate limited, next call allowed in "+wait.Round(time.Millisecond).String(),
+		"too many requests", wait)
+	err.Err = ErrRateLimited
+	return err
+}
+
+// SetRateLimiter limits all calls of this client
+func (h *httpClient) SetRateLimiter(l *RateLimiter) *httpClient {
+	h.mu.Lock()
+	h.limiter = l
+	h.mu.Unlock()
+	return h
+}
+
+// SetHostRateLimiter limits calls to one host (host:port as in the url when the
+// port is not the default), on top of the client limiter
+func (h *httpClient) SetHostRateLimiter(host string, l *RateLimiter) *httpClient {
+	h.hostLimitersMu.Lock()
+	if h.hostLimiters == nil {
+		h.hostLimiters = make(map[string]*RateLimiter)
+	}
+	h.hostLimiters[host] = l
+	h.hostLimitersMu.Unlock()
+	return h
+}
+
+func (h *httpClient) hostRateLimiter(host string) *RateLimiter {
+	h.hostLimitersMu.RLock()
+	defer h.hostLimitersMu.RUnlock()
+	return h.hostLimiters[host]
+}
+
+// waitRateLimit takes a token from the client and the host limiter, the client
+// token is given back when the host limiter rejects the call
+func (h *httpClient) waitRateLimit(req *http.Request) error {
+	h.mu.RLock()
+	limiter := h.limiter
+	h.mu.RUnlock()
+	if limiter != nil {
+		if err := limiter.Wait(req.Context()); err != nil {
+			return err
+		}
+	}
+	if l := h.hostRateLimiter(req.URL.Host); l != nil {
+		if err := l.Wait(req.Context()); err != nil {
+			if limiter != nil {
+				limiter.release()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// observeRateLimit feeds the response headers to the host limiter only, the
+// headers of one host say nothing about the others so the client limiter
+// keeps its own accounting
+func (h *httpClient) observeRateLimit(req *http.Request, resp *http.Response) {
+	if l := h.hostRateLimiter(req.URL.Host); l != nil {
+		l.Observe(resp)
+	}
+}
@@ -0,0 +1,186 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/medatechnology/goutil/medaerror"
+)
+
+func TestRateLimiterAllowRefills(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := NewRateLimiter(2, 2)
+	l.now = func() time.Time { return now }
+
+	if !l.Allow() || !l.Allow() {
+		t.Fatal("burst of 2 must be allowed")
+	}
+	if l.Allow() {
+		t.Fatal("third call must be rejected")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if !l.Allow() {
+		t.Fatal("one token after 500ms at 2/s")
+	}
+}
+
+func TestRateLimiterWaits(t *testing.T) {
+	l := NewRateLimiter(20, 1)
+	l.Wait(context.Background())
+	start := time.Now()
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Fatalf("waited %s, want about 50ms", elapsed)
+	}
+}
+
+func TestRateLimiterFailFast(t *testing.T) {
+	l := NewRateLimiter(1, 1).SetFailFast(true)
+	if err := l.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	err := l.Wait(context.Background())
+	if !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	var medaErr medaerror.MedaError
+	if !errors.As(err, &medaErr) || medaErr.Code != RATE_LIMITED_ERROR {
+		t.Fatalf("err = %#v, want MedaError code %d", err, RATE_LIMITED_ERROR)
+	}
+
+	// a deadline shorter than the wait fails right away as well
+	l = NewRateLimiter(1, 1)
+	l.Allow()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.Wait(ctx); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestRateLimiterObserveHeaders(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	l := NewRateLimiter(100, 10)
+	l.now = func() time.Time { return now }
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	resp.Header.Set(HEADER_RATELIMIT_REMAINING, "0")
+	resp.Header.Set(HEADER_RATELIMIT_RESET, strconv.FormatInt(now.Add(5*time.Second).Unix(), 10))
+	l.Observe(resp)
+	now = now.Add(4 * time.Second)
+	if l.Allow() {
+		t.Fatal("must be blocked until the reset time")
+	}
+	now = now.Add(time.Second)
+	if !l.Allow() {
+		t.Fatal("must be allowed after the reset time")
+	}
+
+	resp = &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "2")
+	l.Observe(resp)
+	now = now.Add(time.Second)
+	if l.Allow() {
+		t.Fatal("must be blocked by Retry-After")
+	}
+	now = now.Add(time.Second)
+	if !l.Allow() {
+		t.Fatal("must be allowed after Retry-After")
+	}
+}
+
+func TestClientHostRateLimiter(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetRetryPolicy(fastRetryPolicy())
+	c.SetHostRateLimiter(mustHost(t, srv.URL), NewRateLimiter(1, 2).SetFailFast(true))
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.Get(srv.URL, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Get(srv.URL, nil, nil); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("err = %v, want ErrRateLimited", err)
+	}
+	if calls != 2 {
+		t.Fatalf("calls = %d, rejected call must not be sent or retried", calls)
+	}
+}
+
+func TestClientRateLimiterIgnoresHostHeaders(t *testing.T) {
+	limited := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HEADER_RATELIMIT_REMAINING, "0")
+		w.Header().Set(HEADER_RATELIMIT_RESET, "30")
+	}))
+	defer limited.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer other.Close()
+
+	c := NewHttp()
+	c.SetRetryPolicy(fastRetryPolicy())
+	c.SetRateLimiter(NewRateLimiter(100, 2).SetFailFast(true))
+	if _, err := c.Get(limited.URL, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// host A said remaining 0, host B is not affected
+	if _, err := c.Get(other.URL, nil, nil); err != nil {
+		t.Fatalf("other host err = %v", err)
+	}
+
+	// a host limiter rejecting the call gives the client token back
+	slow := NewRateLimiter(0.001, 2).SetFailFast(true)
+	c = NewHttp()
+	c.SetRetryPolicy(fastRetryPolicy())
+	c.SetRateLimiter(slow)
+	c.SetHostRateLimiter(mustHost(t, other.URL), NewRateLimiter(0.001, 1).SetFailFast(true))
+	if _, err := c.Get(other.URL, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(other.URL, nil, nil); !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("host limiter err = %v", err)
+		}
+	}
+	if !slow.Allow() {
+		t.Fatal("client token was not given back after the host limiter rejected the call")
+	}
+}
+
+func TestOpenBreakerKeepsRateLimitTokens(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+	host := mustHost(t, srv.URL)
+
+	limiter := NewRateLimiter(0.001, 2).SetFailFast(true)
+	hostLimiter := NewRateLimiter(0.001, 2).SetFailFast(true)
+	c := NewHttp()
+	c.SetCircuitBreaker(NewCircuitBreaker(CircuitBreakerConfig{MinRequests: 1, CoolDown: time.Hour}))
+	c.SetRateLimiter(limiter)
+	c.SetHostRateLimiter(host, hostLimiter)
+
+	c.Get(srv.URL, nil, nil) // opens the breaker, spends one token of each
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get(srv.URL, nil, nil); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("err = %v, want ErrCircuitOpen", err)
+		}
+	}
+	if !limiter.Allow() || !hostLimiter.Allow() {
+		t.Fatal("calls rejected by the open breaker spent rate limit tokens")
+	}
+}
@@ -0,0 +1,192 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// RequestBuilder builds one request on top of the client defaults without
+// changing the client, so a single client can be shared by many goroutines
+// that each need their own headers or query.
+// Usage:
+//
+//	var user User
+//	resp, err := c.R().
+//		Context(ctx).
+//		Header("X-Request-Id", reqID).
+//		Query("tag", "a", "b").                // ?tag=a&tag=b
+//		PathParam("id", userID).               // fills {id}
+//		Result(&user).
+//		Get("https://api.example.com/users/{id}")
+//
+// The client headers and query params are copied when R() is called and that
+// copy is what is sent, later client changes do not apply. Header and Query
+// replace only the keys they name, the other defaults are kept; Header(key)
+// without values removes a client default; AddHeader and AddQuery append. A
+// builder is meant for one goroutine, create a new one with R() for every
+// request.
+type RequestBuilder struct {
+	client        *httpClient
+	ctx           context.Context
+	header        http.Header
+	query         url.Values
+	pathParams    map[string]string
+	body          interface{}
+	result        interface{}
+	errorResponse interface{}
+}
+
+// R starts a request with a copy of the client headers and query params
+func (h *httpClient) R() *RequestBuilder {
+	h.mu.RLock()
+	header := http.Header(h.headers).Clone()
+	query := make(url.Values, len(h.params))
+	for key, value := range h.params {
+		query.Set(key, value)
+	}
+	h.mu.RUnlock()
+	if header == nil {
+		header = http.Header{}
+	}
+	return &RequestBuilder{
+		client: h,
+		ctx:    context.Background(),
+		header: header,
+		query:  query,
+	}
+}
+
+// Context binds the request to ctx, nil is ignored
+func (r *RequestBuilder) Context(ctx context.Context) *RequestBuilder {
+	if ctx != nil {
+		r.ctx = ctx
+	}
+	return r
+}
+
+// Header sets the values of one header, replacing the client default for it
+func (r *RequestBuilder) Header(key string, values ...string) *RequestBuilder {
+	r.header.Del(key)
+	for _, value := range values {
+		r.header.Add(key, value)
+	}
+	return r
+}
+
+// AddHeader appends a value to the header, keeping the client default values
+func (r *RequestBuilder) AddHeader(key, value string) *RequestBuilder {
+	r.header.Add(key, value)
+	return r
+}
+
+// Headers merges headers in, each key replaces the client default for that key
+func (r *RequestBuilder) Headers(headers map[string][]string) *RequestBuilder {
+	for key, values := range headers {
+		r.Header(key, values...)
+	}
+	return r
+}
+
+// Query sets the values of one query param, more values give ?k=a&k=b
+func (r *RequestBuilder) Query(key string, values ...string) *RequestBuilder {
+	r.query[key] = append([]string(nil), values...)
+	return r
+}
+
+// AddQuery appends a value to the query param
+func (r *RequestBuilder) AddQuery(key, value string) *RequestBuilder {
+	r.query.Add(key, value)
+	return r
+}
+
+// Queries merges query params in, each key replaces the existing values
+func (r *RequestBuilder) Queries(query url.Values) *RequestBuilder {
+	for key, values := range query {
+		r.Query(key, values...)
+	}
+	return r
+}
+
+// PathParam fills {name} in the url with the path escaped value
+func (r *RequestBuilder) PathParam(name, value string) *RequestBuilder {
+	if r.pathParams == nil {
+		r.pathParams = make(map[string]string)
+	}
+	r.pathParams[name] = value
+	return r
+}
+
+// PathParams fills several {name} placeholders
+func (r *RequestBuilder) PathParams(params map[string]string) *RequestBuilder {
+	for name, value := range params {
+		r.PathParam(name, value)
+	}
+	return r
+}
+
+// Body sets the request body, encoded like the body of Post (see JSONBody and
+// Multipart for bodies that bring their own encoding)
+func (r *RequestBuilder) Body(body interface{}) *RequestBuilder {
+	r.body = body
+	return r
+}
+
+// Result is where a success body is decoded
+func (r *RequestBuilder) Result(result interface{}) *RequestBuilder {
+	r.result = result
+	return r
+}
+
+// Error is where a json error body is decoded
+func (r *RequestBuilder) Error(errorResponse interface{}) *RequestBuilder {
+	r.errorResponse = errorResponse
+	return r
+}
+
+func (r *RequestBuilder) Get(urll string) (*Response, error) {
+	return r.Send(http.MethodGet, urll)
+}
+
+func (r *RequestBuilder) Post(urll string) (*Response, error) {
+	return r.Send(http.MethodPost, urll)
+}
+
+func (r *RequestBuilder) Put(urll string) (*Response, error) {
+	return r.Send(http.MethodPut, urll)
+}
+
+func (r *RequestBuilder) Patch(urll string) (*Response, error) {
+	return r.Send(http.MethodPatch, urll)
+}
+
+func (r *RequestBuilder) Delete(urll string) (*Response, error) {
+	return r.Send(http.MethodDelete, urll)
+}
+
+func (r *RequestBuilder) Head(urll string) (*Response, error) {
+	return r.Send(http.MethodHead, urll)
+}
+
+func (r *RequestBuilder) Options(urll string) (*Response, error) {
+	return r.Send(http.MethodOptions, urll)
+}
+
+// Send sends the request with any method
+func (r *RequestBuilder) Send(method, urll string) (*Response, error) {
+	ctx := withRequestDefaults(r.ctx, r.header, r.query)
+	return r.client.DoResponse(ctx, method, r.expandPath(urll), r.body, r.result, r.errorResponse)
+}
+
+// expandPath replaces every {name} with its path param
+func (r *RequestBuilder) expandPath(urll string) string {
+	if len(r.pathParams) == 0 {
+		return urll
+	}
+	pairs := make([]string, 0, len(r.pathParams)*2)
+	for name, value := range r.pathParams {
+		pairs = append(pairs, "{"+name+"}", url.PathEscape(value))
+	}
+	return strings.NewReplacer(pairs...).Replace(urll)
+}
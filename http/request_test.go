@@ -0,0 +1,159 @@
+package httpclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type echoRequest struct {
+	Path   string              `json:"path"`
+	Query  map[string][]string `json:"query"`
+	Header map[string][]string `json:"header"`
+}
+
+func echoServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MIME_JSON)
+		json.NewEncoder(w).Encode(echoRequest{
+			Path:   r.URL.EscapedPath(),
+			Query:  r.URL.Query(),
+			Header: r.Header,
+		})
+	}))
+}
+
+func TestRequestBuilder(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetHeader(map[string][]string{"X-Default": {"d"}, "X-Tenant": {"client"}})
+	c.SetQueryParams(map[string]string{"v": "1"})
+
+	var got echoRequest
+	_, err := c.R().
+		Header("X-Tenant", "call").
+		AddHeader("X-Default", "extra").
+		Query("tag", "a", "b").
+		PathParam("id", "a b/c").
+		Result(&got).
+		Get(srv.URL + "/users/{id}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Path != "/users/a%20b%2Fc" {
+		t.Errorf("path = %s", got.Path)
+	}
+	if fmt.Sprint(got.Query["tag"]) != "[a b]" || fmt.Sprint(got.Query["v"]) != "[1]" {
+		t.Errorf("query = %v", got.Query)
+	}
+	if fmt.Sprint(got.Header["X-Tenant"]) != "[call]" || fmt.Sprint(got.Header["X-Default"]) != "[d extra]" {
+		t.Errorf("header = %v", got.Header)
+	}
+
+	// the client itself is untouched
+	got = echoRequest{}
+	if _, err := c.Get(srv.URL, &got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got.Header["X-Tenant"]) != "[client]" || got.Query["tag"] != nil {
+		t.Errorf("client defaults changed: %+v", got)
+	}
+}
+
+func TestSetHeaderCopiesMap(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+
+	headers := map[string][]string{"X-Token": {"one"}}
+	params := map[string]string{"q": "one"}
+	c := NewHttp()
+	c.SetHeader(headers)
+	c.SetQueryParams(params)
+	headers["X-Token"] = []string{"two"}
+	params["q"] = "two"
+
+	var got echoRequest
+	if _, err := c.Get(srv.URL, &got, nil); err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got.Header["X-Token"]) != "[one]" || fmt.Sprint(got.Query["q"]) != "[one]" {
+		t.Errorf("caller map changes leaked in: %+v", got)
+	}
+}
+
+func TestRequestBuilderConcurrent(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+
+	c := NewHttp()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprint(i)
+			var got echoRequest
+			if _, err := c.R().Header("X-Id", id).Query("id", id).Result(&got).Get(srv.URL); err != nil {
+				t.Error(err)
+				return
+			}
+			if fmt.Sprint(got.Header["X-Id"]) != "["+id+"]" || fmt.Sprint(got.Query["id"]) != "["+id+"]" {
+				t.Errorf("request %s got %+v", id, got)
+			}
+			if i%5 == 0 {
+				c.SetHeader(map[string][]string{"X-Round": {id}})
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestRequestBuilderRemovesDefaults(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetHeader(map[string][]string{"X-Default": {"d"}, "X-Keep": {"k"}})
+	c.SetQueryParams(map[string]string{"v": "1"})
+	var got echoRequest
+	if _, err := c.R().Header("X-Default").Result(&got).Get(srv.URL); err != nil {
+		t.Fatal(err)
+	}
+	if got.Header["X-Default"] != nil || fmt.Sprint(got.Header["X-Keep"]) != "[k]" || fmt.Sprint(got.Query["v"]) != "[1]" {
+		t.Errorf("got %+v, want X-Default removed and the other defaults kept", got)
+	}
+}
+
+func TestSettersWhileSending(t *testing.T) {
+	srv := echoServer()
+	defer srv.Close()
+
+	c := NewHttp()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Get(srv.URL, nil, nil); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			c.SetRetryPolicy(fastRetryPolicy()).
+				SetCircuitBreaker(NewCircuitBreaker(DefaultCircuitBreakerConfig())).
+				SetAuthenticator(BearerToken("t")).
+				SetRateLimiter(NewRateLimiter(1000, 1000)).
+				SetCompression(Compression{}).
+				SetInstrumentation(Instrumentation{DisableMetrics: true}).
+				SetMaxResponseSize(1 << 20).
+				Use(func(next http.RoundTripper) http.RoundTripper { return next })
+		}()
+	}
+	wg.Wait()
+}
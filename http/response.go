@@ -0,0 +1,47 @@
+package httpclient
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Response is the rich result of a request, returned by DoResponse and the
+// generic helpers (GetJSON, PostJSON, ...)
+type Response struct {
+	StatusCode StatusCode
+	Status     string // ie: "404 Not Found"
+	Header     http.Header
+	Method     string
+	URL        string
+	Body       []byte // raw body, only kept for error responses (status >= 400)
+	CacheHit   bool   // served by the response cache (fresh entry or 304 revalidation)
+}
+
+func newResponse(response *http.Response) *Response {
+	resp := &Response{
+		StatusCode: StatusCode(response.StatusCode),
+		Status:     response.Status,
+		Header:     response.Header,
+	}
+	if response.Request != nil {
+		resp.Method = response.Request.Method
+		resp.URL = response.Request.URL.String()
+	}
+	return resp
+}
+
+// IsSuccess is true for 2xx
+func (r *Response) IsSuccess() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// isJSONContentType accepts application/json with parameters (charset) and
+// the +json suffix types like application/problem+json
+func isJSONContentType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == MIME_JSON || strings.HasSuffix(mediaType, "+json")
+}
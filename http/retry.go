@@ -0,0 +1,299 @@
+package httpclient
+
+import (
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Retry with exponential backoff and jitter.
+// Usage:
+//
+//	c := httpclient.NewHttp()
+//	c.SetRetryPolicy(httpclient.DefaultRetryPolicy())
+//
+// With the policy set GET, HEAD, OPTIONS, PUT and DELETE are retried on transport
+// errors and on 408/429/502/503/504. POST and PATCH are not idempotent so they
+// are only retried when RetryNonIdempotent is true. A Retry-After header from the
+// server is honored instead of the computed backoff, when it asks for more than
+// MaxRetryAfter the response is returned without further retries.
+
+const (
+	DEFAULT_RETRY_ATTEMPTS   = 3
+	DEFAULT_RETRY_BASE_DELAY = 100 * time.Millisecond
+	DEFAULT_RETRY_MAX_DELAY  = 5 * time.Second
+	DEFAULT_RETRY_MULTIPLIER = 2.0
+	DEFAULT_RETRY_JITTER     = 0.5
+	DEFAULT_RETRY_MAX_AFTER  = 30 * time.Second
+)
+
+type RetryPolicy struct {
+	MaxAttempts        int           // total attempts including the first one, <= 1 means no retry
+	BaseDelay          time.Duration // backoff before the 2nd attempt
+	MaxDelay           time.Duration // cap for the computed backoff
+	Multiplier         float64       // backoff growth per attempt, <= 1 uses DEFAULT_RETRY_MULTIPLIER
+	Jitter             float64       // 0..1, fraction of the backoff that is randomized
+	MaxRetryAfter      time.Duration // longest Retry-After that is waited for, <= 0 uses DEFAULT_RETRY_MAX_AFTER
+	RetryNonIdempotent bool          // also retry POST and PATCH
+	// ShouldRetry decides if the attempt should be retried, nil uses DefaultShouldRetry.
+	// Either resp or err is nil.
+	ShouldRetry func(resp *http.Response, err error) bool
+}
+
+// DefaultRetryPolicy returns the policy with the DEFAULT_RETRY_* values
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:   DEFAULT_RETRY_ATTEMPTS,
+		BaseDelay:     DEFAULT_RETRY_BASE_DELAY,
+		MaxDelay:      DEFAULT_RETRY_MAX_DELAY,
+		Multiplier:    DEFAULT_RETRY_MULTIPLIER,
+		Jitter:        DEFAULT_RETRY_JITTER,
+		MaxRetryAfter: DEFAULT_RETRY_MAX_AFTER,
+	}
+}
+
+// DefaultShouldRetry retries transport errors (connection reset, refused,
+// timeouts, ...) and the usual transient status codes. A call whose context
+// the caller cancelled or let expire is never retried, send checks that before
+// asking the policy.
+func DefaultShouldRetry(resp *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch resp.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// SetRetryPolicy enables retry for this client
+func (h *httpClient) SetRetryPolicy(policy RetryPolicy) *httpClient {
+	h.mu.Lock()
+	h.retry = &policy
+	h.mu.Unlock()
+	return h
+}
+
+// isIdempotent follows RFC 7231 section 4.2.2
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace,
+		http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// attemptsFor returns how many attempts the request is allowed. Requests with a
+// body that cannot be replayed (no GetBody) are never retried.
+func (p *RetryPolicy) attemptsFor(req *http.Request) int {
+	if p == nil || p.MaxAttempts <= 1 {
+		return 1
+	}
+	if !isIdempotent(req.Method) && !p.RetryNonIdempotent {
+		return 1
+	}
+	if !canReplay(req) {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+func (p *RetryPolicy) shouldRetry(resp *http.Response, err error) bool {
+	if p.ShouldRetry != nil {
+		return p.ShouldRetry(resp, err)
+	}
+	return DefaultShouldRetry(resp, err)
+}
+
+// backoff returns how long to wait after the given (1-based) attempt failed.
+// It returns false when the server asks to wait longer than MaxRetryAfter.
+func (p *RetryPolicy) backoff(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if wait, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()); ok {
+			maxRetryAfter := p.MaxRetryAfter
+			if maxRetryAfter <= 0 {
+				maxRetryAfter = DEFAULT_RETRY_MAX_AFTER
+			}
+			return wait, wait <= maxRetryAfter
+		}
+	}
+	base := p.BaseDelay
+	if base <= 0 {
+		base = DEFAULT_RETRY_BASE_DELAY
+	}
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = DEFAULT_RETRY_MAX_DELAY
+	}
+	multiplier := p.Multiplier
+	if multiplier <= 1 {
+		multiplier = DEFAULT_RETRY_MULTIPLIER
+	}
+	delay := float64(base) * math.Pow(multiplier, float64(attempt-1))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	jitter := math.Min(math.Max(p.Jitter, 0), 1)
+	// random value in [delay*(1-jitter), delay]
+	delay = delay*(1-jitter) + rand.Float64()*delay*jitter
+	return time.Duration(delay), true
+}
+
+// parseRetryAfter accepts both forms of the header: delay in seconds or an http date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		wait := at.Sub(now)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// send executes the request through the middleware chain applying the circuit
+// breaker and the retry policy. An open breaker stops the retries right away.
+// Every retry gets a fresh copy of the body from GetBody, the failed response
+// is drained and closed so the connection can be reused.
+func (h *httpClient) send(request *http.Request) (*http.Response, error) {
+	h.mu.RLock()
+	retry, breaker := h.retry, h.breaker
+	instrumentation, compression := h.instrumentation, h.compression
+	h.mu.RUnlock()
+	if instrumentation != nil {
+		request = instrumentation.withTrace(request)
+	}
+	if compression != nil {
+		request = markCallerAcceptEncoding(request)
+	}
+	ctx := request.Context()
+	attempts := retry.attemptsFor(request)
+	rt := h.roundTripper()
+	for attempt := 1; ; attempt++ {
+		response, err := h.attempt(rt, breaker, request, attempt > 1)
+		if attempt >= attempts || ctx.Err() != nil || isRejected(err) || !retry.shouldRetry(response, err) {
+			return response, err
+		}
+		wait, ok := retry.backoff(attempt, response)
+		if !ok {
+			return response, err
+		}
+		drainBody(response)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// attempt authenticates and sends one attempt. When replay is true (or on the
+// 401 refresh) a copy of the request with a fresh body is sent. A 401 with an
+// authenticator that caches tokens is sent once more with new credentials.
+func (h *httpClient) attempt(rt http.RoundTripper, breaker *CircuitBreaker, request *http.Request, replay bool) (*http.Response, error) {
+	refreshed := false
+	for {
+		req := request
+		if replay {
+			var err error
+			if req, err = replayRequest(request); err != nil {
+				return nil, err
+			}
+		}
+		if err := h.authenticate(req); err != nil {
+			closeRequestBody(req)
+			return nil, err
+		}
+		// the breaker goes first so calls it rejects do not spend rate limit tokens
+		if breaker != nil {
+			if err := breaker.Allow(req.URL.Host); err != nil {
+				closeRequestBody(req)
+				return nil, err
+			}
+		}
+		if err := h.waitRateLimit(req); err != nil {
+			if breaker != nil {
+				breaker.Release(req.URL.Host)
+			}
+			closeRequestBody(req)
+			return nil, err
+		}
+		response, err := rt.RoundTrip(req)
+		if breaker != nil {
+			if req.Context().Err() != nil {
+				// cancelled by the caller, not the host's fault
+				breaker.Release(req.URL.Host)
+			} else {
+				breaker.Record(req.URL.Host, response, err)
+			}
+		}
+		h.observeRateLimit(req, response)
+		if err != nil || response.StatusCode != http.StatusUnauthorized || refreshed || !canReplay(request) {
+			return response, err
+		}
+		invalidator, ok := h.authenticator().(TokenInvalidator)
+		if !ok {
+			return response, err
+		}
+		drainBody(response)
+		invalidator.Invalidate()
+		refreshed = true
+		replay = true
+	}
+}
+
+// replayRequest copies the request with a new body from GetBody
+func replayRequest(request *http.Request) (*http.Request, error) {
+	req := request.Clone(request.Context())
+	if request.GetBody != nil {
+		body, err := request.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = body
+	}
+	return req, nil
+}
+
+func canReplay(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
+
+// drainBody reads the rest of the body (up to DRAIN_BODY_LIMIT) and closes it
+// so the connection is reused
+func drainBody(response *http.Response) {
+	if response != nil && response.Body != nil {
+		io.Copy(io.Discard, io.LimitReader(response.Body, DRAIN_BODY_LIMIT))
+		response.Body.Close()
+	}
+}
+
+// isRejected is true for errors made by the client itself (open breaker, fail
+// fast rate limit), retrying them is pointless
+func isRejected(err error) bool {
+	return errors.Is(err, ErrCircuitOpen) || errors.Is(err, ErrRateLimited)
+}
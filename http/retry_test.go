@@ -0,0 +1,163 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func fastRetryPolicy() RetryPolicy {
+	p := DefaultRetryPolicy()
+	p.BaseDelay = time.Millisecond
+	p.MaxDelay = 5 * time.Millisecond
+	return p
+}
+
+func TestRetryReplaysBody(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if string(body) != `{"a":1}` {
+			t.Errorf("attempt %d body = %q", atomic.LoadInt32(&calls)+1, body)
+		}
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetHeader(map[string][]string{"Content-Type": {"application/json"}})
+	policy := fastRetryPolicy()
+	policy.RetryNonIdempotent = true
+	c.SetRetryPolicy(policy)
+
+	code, err := c.Post(srv.URL, map[string]int{"a": 1}, nil, nil)
+	if err != nil || code != http.StatusOK {
+		t.Fatalf("code=%d err=%v", code, err)
+	}
+	if calls != 3 {
+		t.Fatalf("calls = %d, want 3", calls)
+	}
+}
+
+func TestRetryPostIsOptIn(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetRetryPolicy(fastRetryPolicy())
+	code, _ := c.Post(srv.URL, nil, nil, nil)
+	if code != http.StatusBadGateway || calls != 1 {
+		t.Fatalf("POST code=%d calls=%d, want 502 and 1 call", code, calls)
+	}
+
+	calls = 0
+	code, _ = c.Get(srv.URL, nil, nil)
+	if code != http.StatusBadGateway || calls != DEFAULT_RETRY_ATTEMPTS {
+		t.Fatalf("GET code=%d calls=%d, want 502 and %d calls", code, calls, DEFAULT_RETRY_ATTEMPTS)
+	}
+}
+
+func TestRetryAfterHonored(t *testing.T) {
+	var first time.Time
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			first = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if since := time.Since(first); since < 900*time.Millisecond {
+			t.Errorf("retried after %s, want >= 1s", since)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetRetryPolicy(fastRetryPolicy())
+	if code, err := c.Get(srv.URL, nil, nil); err != nil || code != http.StatusOK {
+		t.Fatalf("code=%d err=%v", code, err)
+	}
+}
+
+func TestRetryAfterTooLong(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetRetryPolicy(fastRetryPolicy())
+	start := time.Now()
+	code, _ := c.Get(srv.URL, nil, nil)
+	if code != http.StatusServiceUnavailable || calls != 1 || time.Since(start) > time.Second {
+		t.Fatalf("code=%d calls=%d after %s, want 503 right away", code, calls, time.Since(start))
+	}
+}
+
+func TestRetryTimeout(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	// the first attempt hits the client timeout, the retry succeeds
+	c := NewHttp(WithTimeout(50 * time.Millisecond))
+	c.SetRetryPolicy(fastRetryPolicy())
+	if code, err := c.Get(srv.URL, nil, nil); err != nil || code != http.StatusOK || atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("code=%d err=%v calls=%d, want 200 after 2 calls", code, err, atomic.LoadInt32(&calls))
+	}
+
+	// the caller's deadline is final
+	atomic.StoreInt32(&calls, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := NewHttp().SetRetryPolicy(fastRetryPolicy()).GetCtx(ctx, srv.URL, nil, nil); !errors.Is(err, context.DeadlineExceeded) || atomic.LoadInt32(&calls) != 1 {
+		t.Fatalf("err=%v calls=%d, want deadline exceeded after 1 call", err, atomic.LoadInt32(&calls))
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value string
+		want  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{"-1", 0, false},
+		{now.Add(10 * time.Second).Format(http.TimeFormat), 10 * time.Second, true},
+		{"soon", 0, false},
+	}
+	for _, c := range cases {
+		got, ok := parseRetryAfter(c.value, now)
+		if got != c.want || ok != c.ok {
+			t.Errorf("parseRetryAfter(%q) = %s,%v want %s,%v", c.value, got, ok, c.want, c.ok)
+		}
+	}
+}
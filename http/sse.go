@@ -0,0 +1,270 @@
+package httpclient
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server-Sent Events client built on GetStreamCtx/PostStreamCtx.
+// Usage:
+//
+//	for ev, err := range httpclient.SubscribeSSE(ctx, c, url, httpclient.SSEOptions{Reconnect: true}) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(ev.Event, ev.ID, ev.Data)
+//	}
+//
+//	// LLM style token stream, POST body is sent as json
+//	for ev, err := range httpclient.PostSSE(ctx, c, url, prompt, httpclient.SSEOptions{}) { ... }
+//
+//	// or parse a response you opened yourself
+//	resp, _ := c.GetStreamCtx(ctx, url)
+//	for ev, err := range httpclient.ReadSSE(resp, 0) { ... }
+//
+// Breaking out of the loop or cancelling ctx closes the connection. With
+// Reconnect the stream is opened again after it ends (or fails with a transport
+// error or 5xx), sending Last-Event-ID and waiting the server retry: value.
+
+const (
+	DEFAULT_SSE_MAX_EVENT_SIZE = 1 << 20 // 1MB, per line and per event data
+	DEFAULT_SSE_RETRY_DELAY    = 3 * time.Second
+	SSE_DEFAULT_EVENT          = "message"
+	MIME_EVENT_STREAM          = "text/event-stream"
+)
+
+var ErrSSEEventTooLarge = errors.New("sse event exceeds max event size")
+
+type SSEEvent struct {
+	ID    string // last event id, persists across events until the server changes it
+	Event string // event type, "message" when the server did not send event:
+	Data  string // data: lines joined with \n
+}
+
+type SSEOptions struct {
+	MaxEventSize  int           // bytes, 0 uses DEFAULT_SSE_MAX_EVENT_SIZE
+	Reconnect     bool          // open the stream again when it ends or fails
+	MaxReconnects int           // consecutive failed reconnects before giving up, 0 means no limit
+	RetryDelay    time.Duration // wait before reconnecting, the server retry: field overrides it
+	LastEventID   string        // sent as Last-Event-ID on the first connection (resume)
+}
+
+// SubscribeSSE opens the stream with GET and yields the events
+func SubscribeSSE(ctx context.Context, c HttpClient, urll string, opts SSEOptions) iter.Seq2[SSEEvent, error] {
+	return subscribeSSE(ctx, opts, func(ctx context.Context) (*http.Response, error) {
+		return c.GetStreamCtx(ctx, urll)
+	})
+}
+
+// PostSSE opens the stream with POST (body as json) and yields the events
+func PostSSE(ctx context.Context, c HttpClient, urll string, body any, opts SSEOptions) iter.Seq2[SSEEvent, error] {
+	return subscribeSSE(ctx, opts, func(ctx context.Context) (*http.Response, error) {
+		return c.PostStreamCtx(ctx, urll, body)
+	})
+}
+
+// ReadSSE parses the events of an opened response and closes its body when
+// the loop ends. maxEventSize 0 uses DEFAULT_SSE_MAX_EVENT_SIZE.
+func ReadSSE(resp *http.Response, maxEventSize int) iter.Seq2[SSEEvent, error] {
+	return func(yield func(SSEEvent, error) bool) {
+		defer resp.Body.Close()
+		reader := newSSEReader(resp.Body, maxEventSize)
+		for {
+			ev, err := reader.Next()
+			if err == io.EOF {
+				return
+			}
+			if !yield(ev, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+func subscribeSSE(ctx context.Context, opts SSEOptions, open func(context.Context) (*http.Response, error)) iter.Seq2[SSEEvent, error] {
+	return func(yield func(SSEEvent, error) bool) {
+		lastEventID := opts.LastEventID
+		delay := opts.RetryDelay
+		if delay <= 0 {
+			delay = DEFAULT_SSE_RETRY_DELAY
+		}
+		failures := 0
+		for {
+			header := http.Header{}
+			header.Set("Accept", MIME_EVENT_STREAM)
+			header.Set("Cache-Control", "no-cache")
+			if lastEventID != "" {
+				header.Set("Last-Event-ID", lastEventID)
+			}
+			resp, err := open(withRequestHeaders(ctx, header))
+			retryable := true
+			if err == nil && (resp.StatusCode < 200 || resp.StatusCode >= 300) {
+				err = streamStatusError(resp)
+				retryable = resp.StatusCode >= 500
+			} else if err == nil {
+				failures = 0
+				reader := newSSEReader(resp.Body, opts.MaxEventSize)
+				for {
+					ev, nextErr := reader.Next()
+					if nextErr != nil {
+						if nextErr != io.EOF {
+							err = nextErr
+						}
+						break
+					}
+					if !yield(ev, nil) {
+						resp.Body.Close()
+						return
+					}
+				}
+				resp.Body.Close()
+				lastEventID = reader.lastEventID
+				if reader.retry > 0 {
+					delay = reader.retry
+				}
+				retryable = !errors.Is(err, ErrSSEEventTooLarge)
+			}
+
+			if ctx.Err() != nil {
+				yield(SSEEvent{}, ctx.Err())
+				return
+			}
+			if err != nil {
+				failures++
+			}
+			if !opts.Reconnect || !retryable || (opts.MaxReconnects > 0 && failures > opts.MaxReconnects) {
+				if err != nil {
+					yield(SSEEvent{}, err)
+				}
+				return
+			}
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				yield(SSEEvent{}, ctx.Err())
+				return
+			}
+		}
+	}
+}
+
+// streamStatusError reads (a bounded part of) the body of a failed stream
+// response into *HTTPError and closes it
+func streamStatusError(resp *http.Response) error {
+	defer drainBody(resp)
+	r := newResponse(resp)
+	r.Body, _ = io.ReadAll(io.LimitReader(resp.Body, HTTP_ERROR_BODY_LIMIT+1))
+	return newHTTPError(r, nil)
+}
+
+// sseReader implements the parsing part of the EventSource spec
+// https://html.spec.whatwg.org/multipage/server-sent-events.html#event-stream-interpretation
+type sseReader struct {
+	scanner      *bufio.Scanner
+	maxEventSize int
+	lastEventID  string
+	retry        time.Duration
+}
+
+func newSSEReader(r io.Reader, maxEventSize int) *sseReader {
+	if maxEventSize <= 0 {
+		maxEventSize = DEFAULT_SSE_MAX_EVENT_SIZE
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxEventSize)
+	scanner.Split(scanSSELines)
+	return &sseReader{scanner: scanner, maxEventSize: maxEventSize}
+}
+
+// Next returns the next dispatched event, io.EOF when the stream ended
+func (r *sseReader) Next() (SSEEvent, error) {
+	var data strings.Builder
+	var eventType string
+	hasData := false
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+		if line == "" {
+			// blank line dispatches the event, nothing to dispatch without data
+			if !hasData {
+				eventType = ""
+				continue
+			}
+			if eventType == "" {
+				eventType = SSE_DEFAULT_EVENT
+			}
+			return SSEEvent{
+				ID:    r.lastEventID,
+				Event: eventType,
+				Data:  strings.TrimSuffix(data.String(), "\n"),
+			}, nil
+		}
+		if line[0] == ':' {
+			continue // comment, usually keep-alive
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			if data.Len()+len(value)+1 > r.maxEventSize {
+				return SSEEvent{}, ErrSSEEventTooLarge
+			}
+			data.WriteString(value)
+			data.WriteByte('\n')
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				r.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.Atoi(value); err == nil && ms >= 0 {
+				r.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+	if err := r.scanner.Err(); err != nil {
+		if errors.Is(err, bufio.ErrTooLong) {
+			return SSEEvent{}, ErrSSEEventTooLarge
+		}
+		return SSEEvent{}, err
+	}
+	// the spec drops an event that is not terminated by a blank line
+	return SSEEvent{}, io.EOF
+}
+
+// scanSSELines splits on \r\n, \n or \r
+func scanSSELines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\r' {
+			if i+1 < len(data) {
+				if data[i+1] == '\n' {
+					return i + 2, data[:i], nil
+				}
+				return i + 1, data[:i], nil
+			}
+			if !atEOF {
+				// need one more byte to know if this is \r\n
+				return 0, nil, nil
+			}
+		}
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,127 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSSEReaderSpec(t *testing.T) {
+	stream := ": keep-alive\r\n" +
+		"data: first\r\n\r\n" +
+		"event: token\nid: 7\ndata: a\ndata:b\n\n" +
+		"retry: 1500\n\n" +
+		"data\n\n" +
+		"id: 8\rdata: cr\r\r" +
+		"data: unterminated"
+	r := newSSEReader(strings.NewReader(stream), 0)
+	want := []SSEEvent{
+		{Event: "message", Data: "first"},
+		{ID: "7", Event: "token", Data: "a\nb"},
+		{ID: "7", Event: "message", Data: ""},
+		{ID: "8", Event: "message", Data: "cr"},
+	}
+	for i, w := range want {
+		ev, err := r.Next()
+		if err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		if ev != w {
+			t.Fatalf("event %d = %+v, want %+v", i, ev, w)
+		}
+	}
+	if _, err := r.Next(); err == nil {
+		t.Fatal("unterminated event must not be dispatched")
+	}
+	if r.retry != 1500*time.Millisecond {
+		t.Fatalf("retry = %s", r.retry)
+	}
+}
+
+func TestSSEMaxEventSize(t *testing.T) {
+	stream := "data: " + strings.Repeat("x", 40) + "\ndata: " + strings.Repeat("x", 40) + "\n\n"
+	r := newSSEReader(strings.NewReader(stream), 64)
+	if _, err := r.Next(); !errors.Is(err, ErrSSEEventTooLarge) {
+		t.Fatalf("err = %v, want ErrSSEEventTooLarge", err)
+	}
+	r = newSSEReader(strings.NewReader("data: "+strings.Repeat("x", 100)+"\n\n"), 64)
+	if _, err := r.Next(); !errors.Is(err, ErrSSEEventTooLarge) {
+		t.Fatalf("long line err = %v, want ErrSSEEventTooLarge", err)
+	}
+}
+
+func TestSubscribeSSEReconnect(t *testing.T) {
+	var conns int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != MIME_EVENT_STREAM {
+			t.Errorf("Accept = %q", r.Header.Get("Accept"))
+		}
+		n := atomic.AddInt32(&conns, 1)
+		w.Header().Set("Content-Type", MIME_EVENT_STREAM)
+		switch n {
+		case 1:
+			fmt.Fprint(w, "retry: 10\nid: 1\ndata: one\n\n")
+		case 2:
+			if got := r.Header.Get("Last-Event-ID"); got != "1" {
+				t.Errorf("Last-Event-ID = %q, want 1", got)
+			}
+			fmt.Fprint(w, "id: 2\ndata: two\n\n")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetHeader(map[string][]string{"X-Api-Key": {"k"}})
+	var got []string
+	var lastErr error
+	for ev, err := range SubscribeSSE(context.Background(), c, srv.URL, SSEOptions{Reconnect: true, RetryDelay: time.Second}) {
+		if err != nil {
+			lastErr = err
+			break
+		}
+		got = append(got, ev.Data)
+	}
+	if strings.Join(got, ",") != "one,two" {
+		t.Fatalf("events = %v", got)
+	}
+	if !errors.Is(lastErr, ErrNotFound) {
+		t.Fatalf("err = %v, want 404 to stop the reconnect", lastErr)
+	}
+	if c.(*httpClient).headers["Last-Event-ID"] != nil {
+		t.Fatal("per call header leaked into the client headers")
+	}
+}
+
+func TestPostSSECancel(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MIME_EVENT_STREAM)
+		fmt.Fprint(w, "data: tok\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var lastErr error
+	for ev, err := range PostSSE(ctx, NewHttp(), srv.URL, map[string]string{"prompt": "hi"}, SSEOptions{Reconnect: true}) {
+		if err != nil {
+			lastErr = err
+			break
+		}
+		if ev.Data == "tok" {
+			cancel()
+		}
+	}
+	if !errors.Is(lastErr, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", lastErr)
+	}
+}
@@ -0,0 +1,343 @@
+package httpclient
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/medatechnology/goutil/metrics"
+)
+
+// Tracing and metrics for every attempt: W3C traceparent propagation, DNS /
+// connect / TLS / first byte timings from net/http/httptrace, and counters and
+// latency histograms in a metrics.Registry.
+// Usage:
+//
+//	c := httpclient.NewHttp()
+//	c.SetInstrumentation(httpclient.Instrumentation{
+//		OnDone: func(info httpclient.TraceInfo) {
+//			simplelog.LogFormat("%s %s %d in %s (dns %s, tls %s)", info.Method, info.URL,
+//				info.StatusCode, info.Total, info.DNS, info.TLS)
+//		},
+//	})
+//
+//	// continue the trace of the incoming request
+//	if tp, err := httpclient.ParseTraceParent(r.Header.Get("traceparent")); err == nil {
+//		ctx = httpclient.ContextWithTraceParent(ctx, tp)
+//	}
+//	c.GetCtx(ctx, url, &result, nil)
+//
+//	metrics.Default.WriteText(w) // http_client_requests_total{host,method,status} ...
+//
+// Every attempt is a child span of the trace in the context (a new trace is
+// started once per call when there is none) so retries share the trace id and
+// each gets its own span id. A traceparent header set by the caller (SetHeader,
+// request builder) is kept and sent unchanged. The series are:
+// http_client_requests_total{host,method,status} (status "error" for transport
+// errors), http_client_request_duration_seconds{host,method} (until the
+// response headers) and http_client_{dns,connect,tls,first_byte}_seconds{host}.
+
+const (
+	HEADER_TRACEPARENT = "traceparent"
+	HEADER_TRACESTATE  = "tracestate"
+
+	METRIC_REQUESTS_TOTAL     = "http_client_requests_total"
+	METRIC_REQUEST_DURATION   = "http_client_request_duration_seconds"
+	METRIC_DNS_DURATION       = "http_client_dns_seconds"
+	METRIC_CONNECT_DURATION   = "http_client_connect_seconds"
+	METRIC_TLS_DURATION       = "http_client_tls_seconds"
+	METRIC_FIRST_BYTE_LATENCY = "http_client_first_byte_seconds"
+)
+
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+
+// TraceParent is the W3C trace context https://www.w3.org/TR/trace-context/
+type TraceParent struct {
+	TraceID    [16]byte
+	SpanID     [8]byte
+	Flags      byte   // 0x01 = sampled
+	TraceState string // vendor data, passed on as is
+}
+
+// NewTraceParent starts a new sampled trace
+func NewTraceParent() TraceParent {
+	var tp TraceParent
+	rand.Read(tp.TraceID[:])
+	rand.Read(tp.SpanID[:])
+	tp.Flags = 0x01
+	return tp
+}
+
+// ParseTraceParent parses a traceparent header value (version 00)
+func ParseTraceParent(value string) (TraceParent, error) {
+	var tp TraceParent
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return tp, ErrInvalidTraceParent
+	}
+	if _, err := hex.Decode(tp.TraceID[:], []byte(parts[1])); err != nil {
+		return tp, ErrInvalidTraceParent
+	}
+	if _, err := hex.Decode(tp.SpanID[:], []byte(parts[2])); err != nil {
+		return tp, ErrInvalidTraceParent
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || tp.TraceID == [16]byte{} || tp.SpanID == [8]byte{} {
+		return tp, ErrInvalidTraceParent
+	}
+	tp.Flags = byte(flags)
+	return tp, nil
+}
+
+func (tp TraceParent) String() string {
+	return "00-" + hex.EncodeToString(tp.TraceID[:]) + "-" + hex.EncodeToString(tp.SpanID[:]) +
+		"-" + hex.EncodeToString([]byte{tp.Flags})
+}
+
+// TraceIDString is the trace id in hex, handy for logs
+func (tp TraceParent) TraceIDString() string {
+	return hex.EncodeToString(tp.TraceID[:])
+}
+
+// Child keeps the trace id and flags with a new span id
+func (tp TraceParent) Child() TraceParent {
+	child := tp
+	rand.Read(child.SpanID[:])
+	return child
+}
+
+type traceParentKey struct{}
+
+// ContextWithTraceParent makes the outgoing requests of ctx part of the trace
+func ContextWithTraceParent(ctx context.Context, tp TraceParent) context.Context {
+	return context.WithValue(ctx, traceParentKey{}, tp)
+}
+
+// TraceParentFromContext returns the trace set with ContextWithTraceParent
+func TraceParentFromContext(ctx context.Context) (TraceParent, bool) {
+	tp, ok := ctx.Value(traceParentKey{}).(TraceParent)
+	return tp, ok
+}
+
+// TraceInfo describes one attempt. Phases that did not happen (reused
+// connection, plain http) are 0.
+type TraceInfo struct {
+	Method      string
+	URL         string
+	Host        string
+	StatusCode  int   // 0 when Err is set
+	Err         error // transport error
+	TraceParent string
+	ReusedConn  bool
+	DNS         time.Duration
+	Connect     time.Duration
+	TLS         time.Duration
+	FirstByte   time.Duration // from the start of the attempt to the first response byte
+	Total       time.Duration // from the start of the attempt to the response headers
+}
+
+type Instrumentation struct {
+	Registry           *metrics.Registry // nil uses metrics.Default
+	Buckets            []float64         // latency buckets in seconds, nil uses metrics.DEFAULT_LATENCY_BUCKETS
+	DisableMetrics     bool
+	DisableTraceParent bool // do not set traceparent/tracestate on requests
+
+	OnDNS       func(req *http.Request, d time.Duration)
+	OnConnect   func(req *http.Request, d time.Duration)
+	OnTLS       func(req *http.Request, d time.Duration)
+	OnFirstByte func(req *http.Request, d time.Duration)
+	OnDone      func(info TraceInfo)
+}
+
+// SetInstrumentation enables tracing and metrics for every attempt
+func (h *httpClient) SetInstrumentation(instrumentation Instrumentation) *httpClient {
+	h.mu.Lock()
+	h.instrumentation = &instrumentation
+	h.mu.Unlock()
+	return h
+}
+
+func (in *Instrumentation) registry() *metrics.Registry {
+	if in.Registry == nil {
+		return metrics.Default
+	}
+	return in.Registry
+}
+
+// withTrace starts the trace of one call, so all its attempts (retries, the
+// 401 refresh) are spans of the same trace. Nothing changes when ctx already
+// carries a trace or the caller set the traceparent header itself.
+func (in *Instrumentation) withTrace(request *http.Request) *http.Request {
+	if in.DisableTraceParent || request.Header.Get(HEADER_TRACEPARENT) != "" {
+		return request
+	}
+	if _, ok := TraceParentFromContext(request.Context()); ok {
+		return request
+	}
+	return request.WithContext(ContextWithTraceParent(request.Context(), NewTraceParent()))
+}
+
+// transport instruments one attempt, it is the outermost of the chain. A
+// traceparent header already on the request is sent as is.
+func (in *Instrumentation) transport(next http.RoundTripper) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		info := TraceInfo{Method: req.Method, URL: req.URL.String(), Host: req.URL.Host}
+		timings := &traceTimings{}
+		start := time.Now()
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), timings.clientTrace(start)))
+		if !in.DisableTraceParent {
+			info.TraceParent = req.Header.Get(HEADER_TRACEPARENT)
+			if info.TraceParent == "" {
+				parent, ok := TraceParentFromContext(req.Context())
+				if !ok {
+					parent = NewTraceParent()
+				}
+				span := parent.Child()
+				info.TraceParent = span.String()
+				// the header of the caller's request stays as it was, the next
+				// attempt gets its own span
+				req.Header = req.Header.Clone()
+				req.Header.Set(HEADER_TRACEPARENT, info.TraceParent)
+				if span.TraceState != "" {
+					req.Header.Set(HEADER_TRACESTATE, span.TraceState)
+				}
+			}
+		}
+		resp, err := next.RoundTrip(req)
+		info.Total = time.Since(start)
+		info.Err = err
+		if resp != nil {
+			info.StatusCode = resp.StatusCode
+		}
+		timings.fill(&info)
+
+		in.callbacks(req, info)
+		if !in.DisableMetrics {
+			in.record(info)
+		}
+		return resp, err
+	})
+}
+
+func (in *Instrumentation) callbacks(req *http.Request, info TraceInfo) {
+	if in.OnDNS != nil && info.DNS > 0 {
+		in.OnDNS(req, info.DNS)
+	}
+	if in.OnConnect != nil && info.Connect > 0 {
+		in.OnConnect(req, info.Connect)
+	}
+	if in.OnTLS != nil && info.TLS > 0 {
+		in.OnTLS(req, info.TLS)
+	}
+	if in.OnFirstByte != nil && info.FirstByte > 0 {
+		in.OnFirstByte(req, info.FirstByte)
+	}
+	if in.OnDone != nil {
+		in.OnDone(info)
+	}
+}
+
+func (in *Instrumentation) record(info TraceInfo) {
+	registry := in.registry()
+	status := "error"
+	if info.Err == nil {
+		status = strconv.Itoa(info.StatusCode)
+	}
+	registry.Counter(METRIC_REQUESTS_TOTAL, metrics.Labels{"host": info.Host, "method": info.Method, "status": status}).Inc()
+	registry.Histogram(METRIC_REQUEST_DURATION, metrics.Labels{"host": info.Host, "method": info.Method}, in.Buckets).ObserveDuration(info.Total)
+	host := metrics.Labels{"host": info.Host}
+	for name, d := range map[string]time.Duration{
+		METRIC_DNS_DURATION:       info.DNS,
+		METRIC_CONNECT_DURATION:   info.Connect,
+		METRIC_TLS_DURATION:       info.TLS,
+		METRIC_FIRST_BYTE_LATENCY: info.FirstByte,
+	} {
+		if d > 0 {
+			registry.Histogram(name, host, in.Buckets).ObserveDuration(d)
+		}
+	}
+}
+
+// traceTimings collects the httptrace events, the dial callbacks can run on
+// other goroutines
+type traceTimings struct {
+	mu                  sync.Mutex
+	dnsStart, connStart time.Time
+	tlsStart            time.Time
+	dns, connect, tls   time.Duration
+	firstByte           time.Duration
+	reused              bool
+}
+
+func (t *traceTimings) clientTrace(start time.Time) *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			if !t.dnsStart.IsZero() {
+				t.dns = time.Since(t.dnsStart)
+			}
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			if t.connStart.IsZero() {
+				t.connStart = time.Now()
+			}
+			t.mu.Unlock()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			t.mu.Lock()
+			if err == nil && !t.connStart.IsZero() && t.connect == 0 {
+				t.connect = time.Since(t.connStart)
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			if !t.tlsStart.IsZero() {
+				t.tls = time.Since(t.tlsStart)
+			}
+			t.mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.reused = info.Reused
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Since(start)
+			t.mu.Unlock()
+		},
+	}
+}
+
+func (t *traceTimings) fill(info *TraceInfo) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info.DNS = t.dns
+	info.Connect = t.connect
+	info.TLS = t.tls
+	info.FirstByte = t.firstByte
+	info.ReusedConn = t.reused
+}
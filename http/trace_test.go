@@ -0,0 +1,157 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/medatechnology/goutil/metrics"
+)
+
+func TestTraceParentParse(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tp, err := ParseTraceParent(valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp.String() != valid || tp.TraceIDString() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Fatalf("round trip = %s", tp)
+	}
+	child := tp.Child()
+	if child.TraceID != tp.TraceID || child.SpanID == tp.SpanID || child.Flags != tp.Flags {
+		t.Fatalf("child = %s", child)
+	}
+	for _, invalid := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		if _, err := ParseTraceParent(invalid); !errors.Is(err, ErrInvalidTraceParent) {
+			t.Errorf("ParseTraceParent(%q) err = %v", invalid, err)
+		}
+	}
+}
+
+func TestInstrumentation(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.Header.Get(HEADER_TRACEPARENT))
+		mu.Unlock()
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	host := mustHost(t, srv.URL)
+
+	registry := metrics.NewRegistry()
+	var infos []TraceInfo
+	var connects int
+	c := NewHttp()
+	c.SetInstrumentation(Instrumentation{
+		Registry:  registry,
+		OnConnect: func(req *http.Request, d time.Duration) { connects++ },
+		OnDone:    func(info TraceInfo) { infos = append(infos, info) },
+	})
+
+	parent, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx := ContextWithTraceParent(context.Background(), parent)
+	if _, err := c.GetCtx(ctx, srv.URL, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	c.Get(srv.URL+"/missing", nil, nil)
+
+	if len(received) != 2 || !strings.HasPrefix(received[0], "00-4bf92f3577b34da6a3ce929d0e0e4736-") ||
+		received[0] == parent.String() {
+		t.Fatalf("traceparent = %v, want a child of %s", received, parent)
+	}
+	if _, err := ParseTraceParent(received[1]); err != nil || strings.Contains(received[1], parent.TraceIDString()) {
+		t.Fatalf("request without trace must start a new one, got %q", received[1])
+	}
+	if len(infos) != 2 || infos[0].StatusCode != 200 || infos[1].StatusCode != 404 || infos[0].Total <= 0 {
+		t.Fatalf("infos = %+v", infos)
+	}
+	if infos[0].FirstByte <= 0 || connects != 1 || !infos[1].ReusedConn {
+		t.Fatalf("timings: first byte %s, connects %d, reused %v", infos[0].FirstByte, connects, infos[1].ReusedConn)
+	}
+
+	ok := registry.Counter(METRIC_REQUESTS_TOTAL, metrics.Labels{"host": host, "method": "GET", "status": "200"})
+	notFound := registry.Counter(METRIC_REQUESTS_TOTAL, metrics.Labels{"host": host, "method": "GET", "status": "404"})
+	if ok.Value() != 1 || notFound.Value() != 1 {
+		t.Fatalf("counters 200=%d 404=%d", ok.Value(), notFound.Value())
+	}
+	latency := registry.Histogram(METRIC_REQUEST_DURATION, metrics.Labels{"host": host, "method": "GET"}, nil)
+	if latency.Snapshot().Count != 2 {
+		t.Fatalf("latency count = %d", latency.Snapshot().Count)
+	}
+}
+
+func TestInstrumentationTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	host := mustHost(t, url)
+	srv.Close()
+
+	registry := metrics.NewRegistry()
+	c := NewHttp()
+	c.SetInstrumentation(Instrumentation{Registry: registry, DisableTraceParent: true})
+	if _, err := c.Get(url, nil, nil); err == nil {
+		t.Fatal("closed server must fail")
+	}
+	if registry.Counter(METRIC_REQUESTS_TOTAL, metrics.Labels{"host": host, "method": "GET", "status": "error"}).Value() != 1 {
+		t.Fatal("transport error must be counted with status error")
+	}
+}
+
+func TestInstrumentationRetriesShareTrace(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received = append(received, r.Header.Get(HEADER_TRACEPARENT))
+		n := len(received)
+		mu.Unlock()
+		if n%3 != 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewHttp()
+	c.SetRetryPolicy(fastRetryPolicy())
+	c.SetInstrumentation(Instrumentation{Registry: metrics.NewRegistry()})
+	if _, err := c.Get(srv.URL, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 3 {
+		t.Fatalf("attempts = %d, want 3", len(received))
+	}
+	first, _ := ParseTraceParent(received[0])
+	for i, value := range received[1:] {
+		tp, err := ParseTraceParent(value)
+		if err != nil || tp.TraceID != first.TraceID || tp.SpanID == first.SpanID {
+			t.Fatalf("attempt %d traceparent %q, want a new span of trace %s", i+2, value, first.TraceIDString())
+		}
+	}
+
+	// a traceparent set by the caller is sent unchanged on every attempt
+	own := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	received = nil
+	c.SetHeader(map[string][]string{http.CanonicalHeaderKey(HEADER_TRACEPARENT): {own}})
+	if _, err := c.Get(srv.URL, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 3 || received[0] != own || received[1] != own || received[2] != own {
+		t.Fatalf("traceparent = %v, want %s kept", received, own)
+	}
+}
@@ -0,0 +1,106 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Generic typed helpers. Instead of passing result interface{} they return the
+// decoded T directly, plus the rich Response. When the status is >= 400 the
+// error is *APIError[E] with the error body decoded into E.
+// Usage:
+//
+//	type User struct { ID int `json:"id"` }
+//	type ErrBody struct { Message string `json:"message"` }
+//
+//	user, resp, err := httpclient.GetJSON[User](ctx, c, url)
+//	created, resp, err := httpclient.PostJSONWithError[NewUser, User, ErrBody](ctx, c, url, newUser)
+//	var apiErr *httpclient.APIError[ErrBody]
+//	if errors.As(err, &apiErr) {
+//		fmt.Println(apiErr.Response.StatusCode, apiErr.Payload.Message)
+//	}
+//
+// Request bodies are always sent as json, whatever Content-Type the client has.
+
+// APIError is returned by the generic helpers for status >= 400. It wraps the
+// *HTTPError so errors.Is(err, ErrNotFound) works the same as with Get/Post.
+type APIError[E any] struct {
+	Response *Response
+	Payload  E // decoded error body, zero value if the body was not json or did not fit E
+	err      *HTTPError
+}
+
+func (e *APIError[E]) Error() string {
+	return e.err.Error()
+}
+
+func (e *APIError[E]) Unwrap() error {
+	return e.err
+}
+
+type jsonBody struct {
+	value interface{}
+}
+
+// JSONBody marks the body to be encoded as json regardless of the client
+// Content-Type header, usable with every method that takes a body
+func JSONBody(v interface{}) interface{} {
+	return jsonBody{value: v}
+}
+
+// DoJSON is the base of the generic helpers, body is not sent when nil
+func DoJSON[Req, Resp, E any](ctx context.Context, c HttpClient, method, urll string, body *Req) (Resp, *Response, error) {
+	var payload interface{}
+	if body != nil {
+		payload = JSONBody(body)
+	}
+	return doJSON[Resp, E](ctx, c, method, urll, payload)
+}
+
+// GetJSON sends GET and decodes the response into T
+func GetJSON[T any](ctx context.Context, c HttpClient, urll string) (T, *Response, error) {
+	return doJSON[T, json.RawMessage](ctx, c, http.MethodGet, urll, nil)
+}
+
+// GetJSONWithError is GetJSON with the error body decoded into E
+func GetJSONWithError[T, E any](ctx context.Context, c HttpClient, urll string) (T, *Response, error) {
+	return doJSON[T, E](ctx, c, http.MethodGet, urll, nil)
+}
+
+// PostJSON sends body as json and decodes the response into Resp
+func PostJSON[Req, Resp any](ctx context.Context, c HttpClient, urll string, body Req) (Resp, *Response, error) {
+	return doJSON[Resp, json.RawMessage](ctx, c, http.MethodPost, urll, JSONBody(body))
+}
+
+// PostJSONWithError is PostJSON with the error body decoded into E
+func PostJSONWithError[Req, Resp, E any](ctx context.Context, c HttpClient, urll string, body Req) (Resp, *Response, error) {
+	return doJSON[Resp, E](ctx, c, http.MethodPost, urll, JSONBody(body))
+}
+
+// PutJSON sends body as json with PUT and decodes the response into Resp
+func PutJSON[Req, Resp any](ctx context.Context, c HttpClient, urll string, body Req) (Resp, *Response, error) {
+	return doJSON[Resp, json.RawMessage](ctx, c, http.MethodPut, urll, JSONBody(body))
+}
+
+// DeleteJSON sends DELETE and decodes the response into T
+func DeleteJSON[T any](ctx context.Context, c HttpClient, urll string) (T, *Response, error) {
+	return doJSON[T, json.RawMessage](ctx, c, http.MethodDelete, urll, nil)
+}
+
+func doJSON[Resp, E any](ctx context.Context, c HttpClient, method, urll string, payload interface{}) (Resp, *Response, error) {
+	var result Resp
+	var errBody E
+	resp, err := c.DoResponse(ctx, method, urll, payload, &result, &errBody)
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		if httpErr.DecodeErr != nil {
+			// partly decoded, do not hand out half a payload
+			var zero E
+			errBody = zero
+		}
+		return result, resp, &APIError[E]{Response: resp, Payload: errBody, err: httpErr}
+	}
+	return result, resp, err
+}
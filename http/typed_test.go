@@ -0,0 +1,74 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type testUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type testErrBody struct {
+	Message string `json:"message"`
+}
+
+func TestTypedHelpers(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/users/1":
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.Header().Set("X-Request-ID", "r1")
+			json.NewEncoder(w).Encode(testUser{ID: 1, Name: "meda"})
+		case "/users":
+			if r.Header.Get("Content-Type") != MIME_JSON {
+				t.Errorf("Content-Type = %q, want json", r.Header.Get("Content-Type"))
+			}
+			var u testUser
+			json.NewDecoder(r.Body).Decode(&u)
+			u.ID = 2
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(u)
+		default:
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"no such user"}`))
+		}
+	}))
+	defer srv.Close()
+
+	ctx := context.Background()
+	// client Content-Type is form, PostJSON must still send json
+	c := NewHttp()
+	c.SetHeader(map[string][]string{"Content-Type": {MIME_FORM}})
+
+	user, resp, err := GetJSON[testUser](ctx, c, srv.URL+"/users/1")
+	if err != nil || user.Name != "meda" || resp.Header.Get("X-Request-ID") != "r1" {
+		t.Fatalf("GetJSON = %+v %+v %v", user, resp, err)
+	}
+
+	created, resp, err := PostJSON[testUser, testUser](ctx, c, srv.URL+"/users", testUser{Name: "new"})
+	if err != nil || created.ID != 2 || created.Name != "new" || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("PostJSON = %+v %+v %v", created, resp, err)
+	}
+
+	_, resp, err = GetJSONWithError[testUser, testErrBody](ctx, c, srv.URL+"/users/9")
+	var apiErr *APIError[testErrBody]
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("err = %v, want *APIError", err)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatal("APIError must unwrap to the HTTPError")
+	}
+	if apiErr.Payload.Message != "no such user" || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("apiErr = %+v", apiErr)
+	}
+	if string(resp.Body) != `{"message":"no such user"}` || resp.Method != http.MethodGet {
+		t.Fatalf("raw body = %q method = %s", resp.Body, resp.Method)
+	}
+}
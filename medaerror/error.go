@@ -69,6 +69,11 @@ func (m MedaError) Error() string {
 	return m.Message
 }
 
+// Unwrap returns the original error so errors.Is and errors.As can see through MedaError
+func (m MedaError) Unwrap() error {
+	return m.Err
+}
+
 // Return the response string usually that needed for API handler
 func (m MedaError) Response() string {
 	return m.ResponseString
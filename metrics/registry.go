@@ -0,0 +1,284 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counters and histograms with labels, kept in memory. No exporter SDK needed,
+// read the values with Counters/Histograms or write them in the Prometheus text
+// format with WriteText (ie: from a /metrics handler).
+// Usage:
+//
+//	metrics.Default.Counter("jobs_total", metrics.Labels{"queue": "mail"}).Inc()
+//
+//	start := time.Now()
+//	RunJob()
+//	metrics.Default.Histogram("job_seconds", nil, nil).ObserveDuration(time.Since(start))
+//
+//	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+//		metrics.Default.WriteText(w)
+//	})
+
+// DEFAULT_LATENCY_BUCKETS are upper bounds in seconds, 5ms to 10s
+var DEFAULT_LATENCY_BUCKETS = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry used when none is given
+var Default = NewRegistry()
+
+type Labels map[string]string
+
+type Registry struct {
+	mu         sync.RWMutex
+	counters   map[string]*Counter
+	histograms map[string]*Histogram
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   make(map[string]*Counter),
+		histograms: make(map[string]*Histogram),
+	}
+}
+
+// Counter returns the counter for name and labels, created on first use
+func (r *Registry) Counter(name string, labels Labels) *Counter {
+	key := seriesKey(name, labels)
+	r.mu.RLock()
+	c, ok := r.counters[key]
+	r.mu.RUnlock()
+	if ok {
+		return c
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok = r.counters[key]; !ok {
+		c = &Counter{Name: name, Labels: copyLabels(labels)}
+		r.counters[key] = c
+	}
+	return c
+}
+
+// Histogram returns the histogram for name and labels, created on first use
+// with buckets (nil uses DEFAULT_LATENCY_BUCKETS). Buckets of an existing
+// histogram are not changed.
+func (r *Registry) Histogram(name string, labels Labels, buckets []float64) *Histogram {
+	key := seriesKey(name, labels)
+	r.mu.RLock()
+	h, ok := r.histograms[key]
+	r.mu.RUnlock()
+	if ok {
+		return h
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if h, ok = r.histograms[key]; !ok {
+		if buckets == nil {
+			buckets = DEFAULT_LATENCY_BUCKETS
+		}
+		sorted := append([]float64(nil), buckets...)
+		sort.Float64s(sorted)
+		h = &Histogram{Name: name, Labels: copyLabels(labels), buckets: sorted, counts: make([]uint64, len(sorted))}
+		r.histograms[key] = h
+	}
+	return h
+}
+
+// Counters returns every counter, sorted by name and labels
+func (r *Registry) Counters() []*Counter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]string, 0, len(r.counters))
+	for key := range r.counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	counters := make([]*Counter, len(keys))
+	for i, key := range keys {
+		counters[i] = r.counters[key]
+	}
+	return counters
+}
+
+// Histograms returns every histogram, sorted by name and labels
+func (r *Registry) Histograms() []*Histogram {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	keys := make([]string, 0, len(r.histograms))
+	for key := range r.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	histograms := make([]*Histogram, len(keys))
+	for i, key := range keys {
+		histograms[i] = r.histograms[key]
+	}
+	return histograms
+}
+
+// WriteText writes all series in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	typed := make(map[string]bool)
+	for _, c := range r.Counters() {
+		if !typed[c.Name] {
+			typed[c.Name] = true
+			if _, err := fmt.Fprintf(w, "# TYPE %s counter\n", c.Name); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s%s %d\n", c.Name, formatLabels(c.Labels, "", ""), c.Value()); err != nil {
+			return err
+		}
+	}
+	for _, h := range r.Histograms() {
+		if !typed[h.Name] {
+			typed[h.Name] = true
+			if _, err := fmt.Fprintf(w, "# TYPE %s histogram\n", h.Name); err != nil {
+				return err
+			}
+		}
+		s := h.Snapshot()
+		var cumulative uint64
+		for i, bound := range s.Buckets {
+			cumulative += s.Counts[i]
+			le := formatFloat(bound)
+			if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n", h.Name, formatLabels(h.Labels, "le", le), cumulative); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "%s_bucket%s %d\n%s_sum%s %s\n%s_count%s %d\n",
+			h.Name, formatLabels(h.Labels, "le", "+Inf"), s.Count,
+			h.Name, formatLabels(h.Labels, "", ""), formatFloat(s.Sum),
+			h.Name, formatLabels(h.Labels, "", ""), s.Count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Counter struct {
+	Name   string
+	Labels Labels
+	value  atomic.Int64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n int64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() int64 {
+	return c.value.Load()
+}
+
+type Histogram struct {
+	Name    string
+	Labels  Labels
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64 // per bucket, not cumulative; values over the last bound only count in count
+	count   uint64
+	sum     float64
+}
+
+// HistogramSnapshot is a consistent copy of the histogram values
+type HistogramSnapshot struct {
+	Buckets []float64 // upper bounds
+	Counts  []uint64  // observations per bucket (not cumulative)
+	Count   uint64
+	Sum     float64
+}
+
+func (h *Histogram) Observe(value float64) {
+	i := sort.SearchFloat64s(h.buckets, value)
+	h.mu.Lock()
+	if i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.count++
+	h.sum += value
+	h.mu.Unlock()
+}
+
+// ObserveDuration observes d in seconds
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return HistogramSnapshot{
+		Buckets: append([]float64(nil), h.buckets...),
+		Counts:  append([]uint64(nil), h.counts...),
+		Count:   h.count,
+		Sum:     h.sum,
+	}
+}
+
+// Quantile estimates the q (0..1) quantile from the buckets, like
+// Prometheus histogram_quantile. Values over the last bound return the last bound.
+func (s HistogramSnapshot) Quantile(q float64) float64 {
+	if s.Count == 0 || len(s.Buckets) == 0 {
+		return math.NaN()
+	}
+	rank := q * float64(s.Count)
+	var cumulative uint64
+	lower := 0.0
+	for i, bound := range s.Buckets {
+		prev := cumulative
+		cumulative += s.Counts[i]
+		if float64(cumulative) >= rank && s.Counts[i] > 0 {
+			return lower + (bound-lower)*(rank-float64(prev))/float64(s.Counts[i])
+		}
+		lower = bound
+	}
+	return s.Buckets[len(s.Buckets)-1]
+}
+
+func seriesKey(name string, labels Labels) string {
+	return name + formatLabels(labels, "", "")
+}
+
+func copyLabels(labels Labels) Labels {
+	copied := make(Labels, len(labels))
+	for k, v := range labels {
+		copied[k] = v
+	}
+	return copied
+}
+
+// formatLabels gives {a="1",b="2"} sorted by name, extra is appended when set
+func formatLabels(labels Labels, extraName, extraValue string) string {
+	if len(labels) == 0 && extraName == "" {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		parts = append(parts, name+`="`+labelEscaper.Replace(labels[name])+`"`)
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(f float64) string {
+	return fmt.Sprintf("%g", f)
+}
@@ -0,0 +1,55 @@
+package metrics
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRegistryCounter(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests_total", Labels{"host": "a", "status": "200"}).Inc()
+	r.Counter("requests_total", Labels{"status": "200", "host": "a"}).Add(2)
+	r.Counter("requests_total", Labels{"host": "b", "status": "500"}).Inc()
+
+	counters := r.Counters()
+	if len(counters) != 2 {
+		t.Fatalf("counters = %d, want 2 series", len(counters))
+	}
+	if counters[0].Labels["host"] != "a" || counters[0].Value() != 3 {
+		t.Fatalf("counter a = %+v %d", counters[0].Labels, counters[0].Value())
+	}
+}
+
+func TestRegistryHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.Histogram("latency_seconds", Labels{"host": "a"}, []float64{0.1, 1})
+	h.ObserveDuration(50 * time.Millisecond)
+	h.ObserveDuration(500 * time.Millisecond)
+	h.ObserveDuration(5 * time.Second)
+
+	s := h.Snapshot()
+	if s.Count != 3 || s.Counts[0] != 1 || s.Counts[1] != 1 || math.Abs(s.Sum-5.55) > 1e-9 {
+		t.Fatalf("snapshot = %+v", s)
+	}
+	if q := s.Quantile(0.5); q < 0.1 || q > 1 {
+		t.Fatalf("median = %v", q)
+	}
+
+	var out strings.Builder
+	if err := r.WriteText(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# TYPE latency_seconds histogram",
+		`latency_seconds_bucket{host="a",le="0.1"} 1`,
+		`latency_seconds_bucket{host="a",le="1"} 2`,
+		`latency_seconds_bucket{host="a",le="+Inf"} 3`,
+		`latency_seconds_count{host="a"} 3`,
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("missing %q in:\n%s", want, out.String())
+		}
+	}
+}